@@ -0,0 +1,106 @@
+package azauth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ACRUsername is the username registries accept ACR refresh tokens with, e.g. as the password of docker login.
+const ACRUsername = "00000000-0000-0000-0000-000000000000"
+
+// acrTokens is the response of the ACR exchange and token endpoints.
+type acrTokens struct {
+	RefreshToken string `json:"refresh_token"`
+	AccessToken  string `json:"access_token"`
+}
+
+// ACRRefreshToken exchanges an AAD token of the Config's identity for a refresh token of registry, the
+// registry's login server, e.g. myregistry.azurecr.io. The refresh token is the password of ACRUsername for
+// clients that log in to registries, such as docker and oras, and redeems scoped access tokens with
+// ACRAccessToken. It is valid for about three hours.
+func (c *Config) ACRRefreshToken(ctx context.Context, registry string) (string, error) {
+	registry = acrLoginServer(registry)
+	aadToken, err := c.accessToken(ctx, c.armResource())
+	if err != nil {
+		return "", err
+	}
+	v := url.Values{}
+	v.Set("grant_type", "access_token")
+	v.Set("service", registry)
+	v.Set("access_token", aadToken)
+	if tenant := c.tenantID(); tenant != "" {
+		v.Set("tenant", tenant)
+	}
+	tokens, err := c.acrRequest(ctx, "https://"+registry+"/oauth2/exchange", v)
+	if err != nil {
+		return "", err
+	}
+	if tokens.RefreshToken == "" {
+		return "", fmt.Errorf("the exchange with %s returned no refresh token", registry)
+	}
+	return tokens.RefreshToken, nil
+}
+
+// ACRAccessToken returns an access token of registry for scope, e.g. repository:myimage:pull,push to push
+// and pull an image or registry:catalog:* to list repositories, redeeming a refresh token from
+// ACRRefreshToken. Access tokens are bearer tokens for the registry's /v2/ API.
+func (c *Config) ACRAccessToken(ctx context.Context, registry, scope string) (string, error) {
+	registry = acrLoginServer(registry)
+	refreshToken, err := c.ACRRefreshToken(ctx, registry)
+	if err != nil {
+		return "", err
+	}
+	v := url.Values{}
+	v.Set("grant_type", "refresh_token")
+	v.Set("service", registry)
+	v.Set("scope", scope)
+	v.Set("refresh_token", refreshToken)
+	tokens, err := c.acrRequest(ctx, "https://"+registry+"/oauth2/token", v)
+	if err != nil {
+		return "", err
+	}
+	if tokens.AccessToken == "" {
+		return "", fmt.Errorf("%s returned no access token for %s", registry, scope)
+	}
+	return tokens.AccessToken, nil
+}
+
+// acrLoginServer returns the host of registry, which may be given as a URL.
+func acrLoginServer(registry string) string {
+	registry = strings.TrimPrefix(registry, "https://")
+	return strings.TrimSuffix(registry, "/")
+}
+
+// acrRequest posts v to the ACR OAuth2 endpoint and decodes the tokens in the response.
+func (c *Config) acrRequest(ctx context.Context, endpoint string, v url.Values) (*acrTokens, error) {
+	req, err := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(v.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := c.httpClient().Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	buf := getBuffer()
+	defer putBuffer(buf)
+	if _, err := buf.ReadFrom(resp.Body); err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, tokenRequestError{
+			message: fmt.Sprintf("token request to %s failed with status %d: %s", req.URL.Host, resp.StatusCode, buf.Bytes()),
+			resp:    resp,
+		}
+	}
+	var tokens acrTokens
+	if err := json.Unmarshal(buf.Bytes(), &tokens); err != nil {
+		return nil, err
+	}
+	return &tokens, nil
+}
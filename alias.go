@@ -0,0 +1,71 @@
+package azauth
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/azure"
+)
+
+// ResourceAlias derives the audience of a service from the cloud environment.
+type ResourceAlias func(env azure.Environment) string
+
+var (
+	aliasesMu sync.RWMutex
+	// aliases maps logical service names to their audience in each cloud.
+	aliases = map[string]ResourceAlias{
+		"arm":          armAudience,
+		"keyvault":     keyVaultAudience,
+		"storage":      storageAudience,
+		"graph":        func(env azure.Environment) string { return env.ResourceIdentifiers.MicrosoftGraph },
+		"aadgraph":     func(env azure.Environment) string { return env.ResourceIdentifiers.Graph },
+		"batch":        func(env azure.Environment) string { return env.ResourceIdentifiers.Batch },
+		"datalake":     func(env azure.Environment) string { return env.ResourceIdentifiers.Datalake },
+		"loganalytics": logAnalyticsAudience,
+		"appinsights":  applicationInsightsAudience,
+		"ossrdbms":     func(env azure.Environment) string { return env.ResourceIdentifiers.OSSRDBMS },
+		"synapse":      func(env azure.Environment) string { return env.ResourceIdentifiers.Synapse },
+		"servicebus":   func(env azure.Environment) string { return env.ResourceIdentifiers.ServiceBus },
+		"sql":          func(env azure.Environment) string { return env.ResourceIdentifiers.SQLDatabase },
+		"cosmosdb":     func(env azure.Environment) string { return env.ResourceIdentifiers.CosmosDB },
+		"managedhsm":   managedHSMAudience,
+		"redis":        redisAudience,
+	}
+)
+
+// RegisterResourceAlias registers name, case insensitively, as an alias for the audience alias derives,
+// replacing any existing alias. Built in aliases are arm, keyvault, storage, graph (Microsoft Graph), aadgraph,
+// batch, datalake, loganalytics, appinsights, ossrdbms, synapse, servicebus, sql, cosmosdb, managedhsm, and redis.
+func RegisterResourceAlias(name string, alias ResourceAlias) {
+	aliasesMu.Lock()
+	defer aliasesMu.Unlock()
+	aliases[strings.ToLower(name)] = alias
+}
+
+// ResourceForAlias returns the audience alias names in the Config's cloud, failing for unknown aliases and
+// services the cloud doesn't offer.
+func (c *Config) ResourceForAlias(alias string) (string, error) {
+	aliasesMu.RLock()
+	derive, ok := aliases[strings.ToLower(alias)]
+	aliasesMu.RUnlock()
+	if !ok {
+		return "", fmt.Errorf("unknown resource alias %q", alias)
+	}
+	resource := derive(*c.env)
+	if resource == "" || resource == azure.NotAvailable {
+		return "", fmt.Errorf("resource %q is not available in %s", alias, c.env.Name)
+	}
+	return resource, nil
+}
+
+// AuthorizeClientForAlias authorizes client for the service alias names in the Config's cloud, e.g. keyvault,
+// so code doesn't hard-code public cloud audiences that fail in sovereign clouds.
+func (c *Config) AuthorizeClientForAlias(client *autorest.Client, alias string) error {
+	resource, err := c.ResourceForAlias(alias)
+	if err != nil {
+		return err
+	}
+	return c.AuthorizeClientForResource(client, resource)
+}
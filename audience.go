@@ -0,0 +1,108 @@
+package azauth
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/Azure/go-autorest/autorest/azure"
+)
+
+// AudienceError is returned when a token is requested for a resource of a different cloud than the Config's,
+// which AAD would otherwise reject with the less helpful AADSTS500011.
+type AudienceError struct {
+	// Resource is the requested resource.
+	Resource string
+	// Cloud is the cloud the resource belongs to.
+	Cloud string
+	// Expected is the Config's cloud.
+	Expected string
+	// Suggestion is the equivalent resource in the Config's cloud, if there is one.
+	Suggestion string
+}
+
+// Error implements error.
+func (e *AudienceError) Error() string {
+	msg := fmt.Sprintf("resource %s belongs to %s, but the Config targets %s", e.Resource, e.Cloud, e.Expected)
+	if e.Suggestion != "" {
+		msg += fmt.Sprintf(", use %s instead", e.Suggestion)
+	}
+	return msg
+}
+
+// WithoutAudienceValidation skips checking that resources belong to the Config's cloud.
+func WithoutAudienceValidation() Option {
+	return func(c *Config) {
+		c.skipAudienceCheck = true
+	}
+}
+
+// audienceHosts lists the hosts and DNS suffixes of a cloud's services, one entry per service, so the
+// counterpart of a host in another cloud is found at the same index.
+func audienceHosts(env azure.Environment) []string {
+	ids := env.ResourceIdentifiers
+	values := []string{
+		env.ResourceManagerEndpoint, env.ServiceManagementEndpoint,
+		ids.KeyVault, ids.ManagedHSM, ids.Graph, ids.MicrosoftGraph, ids.Batch, ids.Datalake,
+		ids.OperationalInsights, ids.OSSRDBMS, ids.Synapse, ids.ServiceBus, ids.SQLDatabase, ids.CosmosDB,
+		env.StorageEndpointSuffix, env.SQLDatabaseDNSSuffix, env.ServiceBusEndpointSuffix,
+		env.ContainerRegistryDNSSuffix, env.CosmosDBDNSSuffix, env.MySQLDatabaseDNSSuffix,
+		env.PostgresqlDatabaseDNSSuffix, env.MariaDBDNSSuffix, env.SynapseEndpointSuffix, env.DatalakeSuffix,
+	}
+	hosts := make([]string, len(values))
+	for i, value := range values {
+		if value == azure.NotAvailable {
+			continue
+		}
+		if u, err := url.Parse(value); err == nil && u.Host != "" {
+			value = u.Hostname()
+		}
+		hosts[i] = strings.ToLower(strings.Trim(value, "./"))
+	}
+	return hosts
+}
+
+// matchesHost reports whether host is candidate or a subdomain of it.
+func matchesHost(host, candidate string) bool {
+	return candidate != "" && (host == candidate || strings.HasSuffix(host, "."+candidate))
+}
+
+// validateAudience fails with an *AudienceError when resource belongs to another well known cloud.
+// Application ID URIs and hosts no cloud claims are accepted.
+func (c *Config) validateAudience(resource string) error {
+	if c.skipAudienceCheck {
+		return nil
+	}
+	u, err := url.Parse(resource)
+	if err != nil || u.Host == "" {
+		return nil
+	}
+	host := strings.ToLower(u.Hostname())
+	current := audienceHosts(*c.env)
+	for _, candidate := range current {
+		if matchesHost(host, candidate) {
+			return nil
+		}
+	}
+	for _, env := range []azure.Environment{azure.PublicCloud, azure.USGovernmentCloud, azure.ChinaCloud, azure.GermanCloud} {
+		if strings.EqualFold(env.Name, c.env.Name) {
+			continue
+		}
+		for i, candidate := range audienceHosts(env) {
+			if !matchesHost(host, candidate) {
+				continue
+			}
+			err := &AudienceError{Resource: resource, Cloud: env.Name, Expected: c.env.Name}
+			if counterpart := current[i]; counterpart != "" {
+				suggestion := *u
+				suggestion.Host = strings.TrimSuffix(host, candidate) + counterpart
+				if port := u.Port(); port != "" {
+					suggestion.Host += ":" + port
+				}
+				err.Suggestion = suggestion.String()
+			}
+			return err
+		}
+	}
+	return nil
+}
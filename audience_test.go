@@ -0,0 +1,78 @@
+package azauth
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Azure/go-autorest/autorest/azure"
+)
+
+func TestValidateAudience(t *testing.T) {
+	for _, tc := range []struct {
+		name           string
+		env            azure.Environment
+		skip           bool
+		resource       string
+		wantCloud      string
+		wantSuggestion string
+	}{
+		{name: "resource manager", env: azure.PublicCloud, resource: "https://management.azure.com/"},
+		{name: "key vault", env: azure.PublicCloud, resource: "https://vault.azure.net"},
+		{name: "storage account", env: azure.PublicCloud, resource: "https://myaccount.blob.core.windows.net"},
+		{name: "application ID URI", env: azure.PublicCloud, resource: "api://00000000-0000-0000-0000-000000000000"},
+		{name: "unclaimed host", env: azure.PublicCloud, resource: "https://api.example.com"},
+		{name: "not a URL", env: azure.PublicCloud, resource: "00000000-0000-0000-0000-000000000000"},
+		{
+			name:           "government resource manager in the public cloud",
+			env:            azure.PublicCloud,
+			resource:       "https://management.usgovcloudapi.net/",
+			wantCloud:      azure.USGovernmentCloud.Name,
+			wantSuggestion: "https://management.azure.com/",
+		},
+		{
+			name:           "public key vault in the government cloud",
+			env:            azure.USGovernmentCloud,
+			resource:       "https://myvault.vault.azure.net",
+			wantCloud:      azure.PublicCloud.Name,
+			wantSuggestion: "https://myvault.vault.usgovcloudapi.net",
+		},
+		{
+			name:           "china storage account in the public cloud",
+			env:            azure.PublicCloud,
+			resource:       "https://myaccount.blob.core.chinacloudapi.cn:443/container",
+			wantCloud:      azure.ChinaCloud.Name,
+			wantSuggestion: "https://myaccount.blob.core.windows.net:443/container",
+		},
+		{
+			name:     "validation skipped",
+			env:      azure.PublicCloud,
+			skip:     true,
+			resource: "https://management.usgovcloudapi.net/",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := []Option{WithAzureEnvironment(tc.env)}
+			if tc.skip {
+				opts = append(opts, WithoutAudienceValidation())
+			}
+			c := newTestConfig(t, opts...)
+			err := c.validateAudience(tc.resource)
+			if tc.wantCloud == "" {
+				if err != nil {
+					t.Fatalf("validateAudience() = %v, want nil", err)
+				}
+				return
+			}
+			var audienceErr *AudienceError
+			if !errors.As(err, &audienceErr) {
+				t.Fatalf("validateAudience() = %v, want an *AudienceError", err)
+			}
+			if audienceErr.Cloud != tc.wantCloud || audienceErr.Expected != tc.env.Name {
+				t.Errorf("error clouds = %s, %s, want %s, %s", audienceErr.Cloud, audienceErr.Expected, tc.wantCloud, tc.env.Name)
+			}
+			if audienceErr.Suggestion != tc.wantSuggestion {
+				t.Errorf("Suggestion = %q, want %q", audienceErr.Suggestion, tc.wantSuggestion)
+			}
+		})
+	}
+}
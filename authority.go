@@ -0,0 +1,163 @@
+package azauth
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/adal"
+	"github.com/Azure/go-autorest/logger"
+)
+
+const (
+	// adfsTenant is the tenant segment ADFS uses in place of an AAD tenant ID.
+	adfsTenant = "adfs"
+
+	// regionNameEnv is set by some Azure hosts to the region they run in.
+	regionNameEnv = "REGION_NAME"
+	// regionDetectTimeout bounds region detection through IMDS.
+	regionDetectTimeout = 2 * time.Second
+)
+
+// WithADFS targets an ADFS authority, as used by Azure Stack Hub and on-premises federation, instead of AAD.
+// Token requests go to <authority>/adfs/oauth2/token without a tenant or api-version, and no AAD instance
+// discovery is attempted. Environments whose ActiveDirectoryEndpoint ends in /adfs are treated as ADFS
+// without this option.
+func WithADFS() Option {
+	return func(c *Config) {
+		c.adfs = true
+	}
+}
+
+// WithB2C targets an Azure AD B2C tenant and user flow instead of AAD. tenant is the B2C tenant name
+// (contoso for contoso.onmicrosoft.com) and policy the user flow or custom policy, e.g. B2C_1_signupsignin.
+// B2C only serves the v2 endpoints, so resources are requested as their /.default scope.
+func WithB2C(tenant, policy string) Option {
+	return func(c *Config) {
+		c.b2cTenant = tenant
+		c.b2cPolicy = policy
+	}
+}
+
+// WithRegionalAuthority routes confidential client token requests (client secrets, certificates, and
+// federated assertions) to the regional AAD token service (ESTS-R) in region, for lower latency and
+// isolation from global outages. When region is empty it is detected from REGION_NAME or IMDS, falling back
+// to the global endpoint when detection fails. It has no effect on ADFS or B2C authorities.
+func WithRegionalAuthority(region string) Option {
+	return func(c *Config) {
+		c.regional = true
+		c.region = region
+	}
+}
+
+// isADFS reports whether the configured authority is ADFS.
+func (c *Config) isADFS() bool {
+	return c.adfs || strings.HasSuffix(strings.TrimSuffix(c.env.ActiveDirectoryEndpoint, "/"), "/"+adfsTenant)
+}
+
+// oauthConfig returns the token endpoints for the configured tenant and authority.
+func (c *Config) oauthConfig() (*adal.OAuthConfig, error) {
+	return c.tenantOAuthConfig(c.tenantID())
+}
+
+// tenantOAuthConfig returns the token endpoints for tenant on the configured authority. ADFS and B2C
+// authorities have no AAD tenant, so tenant is ignored for them.
+func (c *Config) tenantOAuthConfig(tenant string) (*adal.OAuthConfig, error) {
+	if c.b2cPolicy != "" {
+		return c.b2cOAuthConfig()
+	}
+	if c.isADFS() {
+		// ADFS endpoints are addressed like a tenant named adfs, without the AAD api-version.
+		authority := strings.TrimSuffix(strings.TrimSuffix(c.env.ActiveDirectoryEndpoint, "/"), "/"+adfsTenant)
+		return adal.NewOAuthConfigWithAPIVersion(authority+"/", adfsTenant, nil)
+	}
+	return adal.NewOAuthConfig(c.env.ActiveDirectoryEndpoint, tenant)
+}
+
+// confidentialOAuthConfig returns the token endpoints used by confidential client credentials,
+// which are regional when WithRegionalAuthority is set.
+func (c *Config) confidentialOAuthConfig() (*adal.OAuthConfig, error) {
+	if !c.regional || c.b2cPolicy != "" || c.isADFS() {
+		return c.oauthConfig()
+	}
+	region := c.detectRegion()
+	if region == "" {
+		return c.oauthConfig()
+	}
+	u, err := url.Parse(c.env.ActiveDirectoryEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	// the public cloud's regional endpoints live under login.microsoft.com rather than login.microsoftonline.com.
+	if u.Host == "login.microsoftonline.com" {
+		u.Host = "login.microsoft.com"
+	}
+	u.Host = region + "." + u.Host
+	return adal.NewOAuthConfig(u.String(), c.tenantID())
+}
+
+// detectRegion returns the configured region, detecting it once when none was given.
+func (c *Config) detectRegion() string {
+	c.regionOnce.Do(func() {
+		if c.region != "" {
+			return
+		}
+		if c.region = os.Getenv(regionNameEnv); c.region != "" {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), regionDetectTimeout)
+		defer cancel()
+		region, err := c.imdsMetadata(ctx, "compute/location")
+		if err != nil {
+			logger.Instance.Writef(logger.LogWarning, "azauth: failed to detect region, using the global authority: %v\n", err)
+			return
+		}
+		c.region = region
+	})
+	return strings.ToLower(strings.Replace(c.region, " ", "", -1))
+}
+
+// b2cOAuthConfig returns the v2 endpoints of the configured B2C user flow, of the form
+// https://<tenant>.b2clogin.com/<tenant>.onmicrosoft.com/<policy>/oauth2/v2.0/token.
+func (c *Config) b2cOAuthConfig() (*adal.OAuthConfig, error) {
+	authority, err := url.Parse(fmt.Sprintf("https://%[1]s.b2clogin.com/%[1]s.onmicrosoft.com/%[2]s/", c.b2cTenant, c.b2cPolicy))
+	if err != nil {
+		return nil, err
+	}
+	authorize, err := authority.Parse("oauth2/v2.0/authorize")
+	if err != nil {
+		return nil, err
+	}
+	token, err := authority.Parse("oauth2/v2.0/token")
+	if err != nil {
+		return nil, err
+	}
+	return &adal.OAuthConfig{
+		AuthorityEndpoint: *authority,
+		AuthorizeEndpoint: *authorize,
+		TokenEndpoint:     *token,
+	}, nil
+}
+
+// isV2Endpoint reports whether endpoint is an AAD v2 endpoint, which takes scopes instead of resources.
+func isV2Endpoint(endpoint url.URL) bool {
+	return strings.Contains(endpoint.Path, "/oauth2/v2.0/")
+}
+
+// toV2 rewrites the v1 resource parameter in v into the equivalent v2 scope for v2 endpoints.
+// User flows additionally request offline_access so a refresh token is issued.
+func toV2(endpoint url.URL, v url.Values) {
+	resource := v.Get("resource")
+	if !isV2Endpoint(endpoint) || resource == "" {
+		return
+	}
+	v.Del("resource")
+	scope := scopeForResource(resource)
+	if v.Get("grant_type") != "client_credentials" {
+		scope += " offline_access"
+	}
+	v.Set("scope", scope)
+}
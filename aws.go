@@ -0,0 +1,214 @@
+package azauth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/adal"
+)
+
+const (
+	awsIMDSEndpoint = "http://169.254.169.254/latest"
+	awsSTSVersion   = "2011-06-15"
+	awsTimeFormat   = "20060102T150405Z"
+
+	// awsTimeout bounds the instance metadata and STS requests made for each web identity token.
+	awsTimeout = 10 * time.Second
+	// awsIMDSTimeout bounds each instance metadata request, so hosts outside EC2 fail fast.
+	awsIMDSTimeout = 2 * time.Second
+)
+
+// WithAWSFederation selects AWS federation as the credential source for workloads running on EC2.
+// The instance's IAM role credentials sign an STS GetWebIdentityToken request, and the resulting JWT
+// is exchanged as a federated client assertion. AWS outbound identity federation must be enabled for
+// the account, and the app registration must trust its issuer. Static AWS_ACCESS_KEY_ID and
+// AWS_SECRET_ACCESS_KEY credentials take precedence over the instance role when set.
+func WithAWSFederation() Option {
+	return func(c *Config) {
+		c.credential = func(c *Config, resource string) (*adal.ServicePrincipalToken, error) {
+			return c.federatedToken(resource, c.awsWebIdentityToken)
+		}
+	}
+}
+
+// awsCredentials are the signing credentials for an AWS request.
+type awsCredentials struct {
+	AccessKeyID     string `json:"AccessKeyId"`
+	SecretAccessKey string `json:"SecretAccessKey"`
+	Token           string `json:"Token"`
+}
+
+// awsWebIdentityToken returns a JWT for the federated audience, signed by AWS STS.
+func (c *Config) awsWebIdentityToken() (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), awsTimeout)
+	defer cancel()
+	client := c.httpClient()
+	creds, region, err := awsEnvironment(ctx, client)
+	if err != nil {
+		return "", err
+	}
+
+	q := url.Values{}
+	q.Set("Action", "GetWebIdentityToken")
+	q.Set("Version", awsSTSVersion)
+	q.Set("Audience.member.1", federatedAudience)
+	q.Set("SigningAlgorithm", "RS256")
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("https://sts.%s.amazonaws.com/?%s", region, q.Encode()), nil)
+	if err != nil {
+		return "", err
+	}
+	signAWSRequest(req, creds, region, "sts", time.Now().UTC())
+
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("aws sts GetWebIdentityToken failed with status %d: %s", resp.StatusCode, body)
+	}
+	var out struct {
+		Token string `xml:"GetWebIdentityTokenResult>WebIdentityToken"`
+	}
+	if err := xml.Unmarshal(body, &out); err != nil {
+		return "", err
+	}
+	if out.Token == "" {
+		return "", errors.New("aws sts GetWebIdentityToken returned no web identity token")
+	}
+	return out.Token, nil
+}
+
+// awsEnvironment returns signing credentials and region from the environment, or from EC2 IMDSv2.
+func awsEnvironment(ctx context.Context, client *http.Client) (awsCredentials, string, error) {
+	creds := awsCredentials{
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		Token:           os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	region := os.Getenv("AWS_REGION")
+	if creds.AccessKeyID != "" && creds.SecretAccessKey != "" && region != "" {
+		return creds, region, nil
+	}
+
+	session, err := awsIMDS(ctx, client, http.MethodPut, "/api/token", "")
+	if err != nil {
+		return creds, "", fmt.Errorf("failed to reach ec2 instance metadata: %v", err)
+	}
+	if region == "" {
+		if region, err = awsIMDS(ctx, client, http.MethodGet, "/meta-data/placement/region", session); err != nil {
+			return creds, "", err
+		}
+	}
+	if creds.AccessKeyID != "" && creds.SecretAccessKey != "" {
+		return creds, region, nil
+	}
+	role, err := awsIMDS(ctx, client, http.MethodGet, "/meta-data/iam/security-credentials/", session)
+	if err != nil {
+		return creds, "", err
+	}
+	role = strings.TrimSpace(strings.SplitN(role, "\n", 2)[0])
+	if role == "" {
+		return creds, "", errors.New("no iam role is attached to this ec2 instance")
+	}
+	raw, err := awsIMDS(ctx, client, http.MethodGet, "/meta-data/iam/security-credentials/"+role, session)
+	if err != nil {
+		return creds, "", err
+	}
+	if err := json.Unmarshal([]byte(raw), &creds); err != nil {
+		return creds, "", err
+	}
+	return creds, region, nil
+}
+
+// awsIMDS issues an IMDSv2 request. An empty session requests a new session token.
+func awsIMDS(ctx context.Context, client *http.Client, method, path, session string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, awsIMDSTimeout)
+	defer cancel()
+	req, err := http.NewRequest(method, awsIMDSEndpoint+path, nil)
+	if err != nil {
+		return "", err
+	}
+	if session == "" {
+		req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "300")
+	} else {
+		req.Header.Set("X-aws-ec2-metadata-token", session)
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("ec2 instance metadata request for %s failed with status %d", path, resp.StatusCode)
+	}
+	return string(body), nil
+}
+
+// signAWSRequest adds an AWS Signature Version 4 Authorization header to a request without a body.
+func signAWSRequest(req *http.Request, creds awsCredentials, region, service string, now time.Time) {
+	amzDate := now.Format(awsTimeFormat)
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	headers := []string{"host", "x-amz-date"}
+	values := []string{req.URL.Host, amzDate}
+	if creds.Token != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.Token)
+		headers = append(headers, "x-amz-security-token")
+		values = append(values, creds.Token)
+	}
+
+	var canonicalHeaders strings.Builder
+	for i := range headers {
+		canonicalHeaders.WriteString(headers[i] + ":" + values[i] + "\n")
+	}
+	signedHeaders := strings.Join(headers, ";")
+	emptyHash := sha256.Sum256(nil)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		"/",
+		strings.Replace(req.URL.Query().Encode(), "+", "%20", -1),
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(emptyHash[:]),
+	}, "\n")
+
+	scope := strings.Join([]string{date, region, service, "aws4_request"}, "/")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, hex.EncodeToString(requestHash[:])}, "\n")
+
+	key := []byte("AWS4" + creds.SecretAccessKey)
+	for _, part := range []string{date, region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
@@ -0,0 +1,151 @@
+package azauth
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// redirectTransport sends every request to the test server, whatever its host.
+type redirectTransport struct {
+	target *url.URL
+}
+
+func (r redirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme = r.target.Scheme
+	req.URL.Host = r.target.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestSignAWSRequest(t *testing.T) {
+	// Requests and signatures from the AWS Signature Version 4 test suite.
+	creds := awsCredentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		name      string
+		url       string
+		signature string
+	}{
+		{
+			name:      "vanilla",
+			url:       "https://example.amazonaws.com/",
+			signature: "5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		},
+		{
+			name:      "query order",
+			url:       "https://example.amazonaws.com/?Param2=value2&Param1=value1",
+			signature: "b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, tc.url, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			signAWSRequest(req, creds, "us-east-1", "service", now)
+			want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=" + tc.signature
+			if got := req.Header.Get("Authorization"); got != want {
+				t.Errorf("Authorization = %q, want %q", got, want)
+			}
+			if got := req.Header.Get("X-Amz-Date"); got != "20150830T123600Z" {
+				t.Errorf("X-Amz-Date = %q", got)
+			}
+		})
+	}
+
+	t.Run("session token", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, "https://example.amazonaws.com/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		withToken := creds
+		withToken.Token = "session"
+		signAWSRequest(req, withToken, "us-east-1", "service", now)
+		if got := req.Header.Get("X-Amz-Security-Token"); got != "session" {
+			t.Errorf("X-Amz-Security-Token = %q, want session", got)
+		}
+		if got := req.Header.Get("Authorization"); !strings.Contains(got, "SignedHeaders=host;x-amz-date;x-amz-security-token,") {
+			t.Errorf("the session token isn't signed: %s", got)
+		}
+	})
+}
+
+func TestAWSWebIdentityToken(t *testing.T) {
+	const stsResponse = `<GetWebIdentityTokenResponse><GetWebIdentityTokenResult><WebIdentityToken>%s</WebIdentityToken></GetWebIdentityTokenResult></GetWebIdentityTokenResponse>`
+	for _, tc := range []struct {
+		name      string
+		static    bool
+		stsStatus int
+		stsToken  string
+		want      string
+		wantErr   string
+	}{
+		{name: "static credentials", static: true, stsStatus: http.StatusOK, stsToken: "jwt", want: "jwt"},
+		{name: "instance role", stsStatus: http.StatusOK, stsToken: "jwt", want: "jwt"},
+		{name: "empty token", static: true, stsStatus: http.StatusOK, wantErr: "no web identity token"},
+		{name: "sts failure", static: true, stsStatus: http.StatusForbidden, wantErr: "status 403"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.static {
+				t.Setenv("AWS_ACCESS_KEY_ID", "AKID")
+				t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+				t.Setenv("AWS_REGION", "us-west-2")
+			} else {
+				t.Setenv("AWS_ACCESS_KEY_ID", "")
+				t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+				t.Setenv("AWS_REGION", "")
+			}
+			t.Setenv("AWS_SESSION_TOKEN", "")
+
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case r.Method == http.MethodPut && r.URL.Path == "/latest/api/token":
+					fmt.Fprint(w, "session")
+				case r.Header.Get("X-aws-ec2-metadata-token") != "session" && strings.HasPrefix(r.URL.Path, "/latest/"):
+					w.WriteHeader(http.StatusUnauthorized)
+				case r.URL.Path == "/latest/meta-data/placement/region":
+					fmt.Fprint(w, "us-west-2")
+				case r.URL.Path == "/latest/meta-data/iam/security-credentials/":
+					fmt.Fprint(w, "role\n")
+				case r.URL.Path == "/latest/meta-data/iam/security-credentials/role":
+					fmt.Fprint(w, `{"AccessKeyId":"AKID","SecretAccessKey":"secret","Token":"session"}`)
+				case r.URL.Query().Get("Action") == "GetWebIdentityToken":
+					if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/") {
+						w.WriteHeader(http.StatusForbidden)
+						return
+					}
+					w.WriteHeader(tc.stsStatus)
+					fmt.Fprintf(w, stsResponse, tc.stsToken)
+				default:
+					w.WriteHeader(http.StatusNotFound)
+				}
+			}))
+			defer srv.Close()
+			target, err := url.Parse(srv.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c := newTestConfig(t)
+			c.client = &http.Client{Transport: redirectTransport{target: target}}
+
+			got, err := c.awsWebIdentityToken()
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("awsWebIdentityToken() error = %v, want %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.want {
+				t.Errorf("awsWebIdentityToken() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
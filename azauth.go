@@ -4,11 +4,23 @@
 package azauth
 
 import (
+	"context"
+	"crypto/rsa"
+	"crypto/x509"
 	"errors"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/adal"
 	"github.com/Azure/go-autorest/autorest/azure"
 	"github.com/Azure/go-autorest/autorest/azure/auth"
+	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/cache"
+	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/confidential"
+	"golang.org/x/sync/singleflight"
 )
 
 // Config holds environment settings, cached authorizers, and global loggers.
@@ -17,13 +29,103 @@ import (
 type Config struct {
 	userAgent string
 	env       *azure.Environment
+	client    *http.Client
 	app       string
 	key       string
 	tenant    string
+
+	settingsMu   sync.RWMutex
+	settings     auth.EnvironmentSettings
+	optionErrs   []error
+	envOverrides []func(*azure.Environment)
+
+	detectCloud       bool
+	metadataEndpoint  string
+	skipAudienceCheck bool
+	armEnvironment    bool
+
+	opts           []Option
+	allowedTenants []string
+	tenantsMu      sync.Mutex
+	tenantConfigs  map[string]*Config
+
+	msiClientID      string
+	msiResourceID    string
+	msiObjectID      string
+	imdsHost         string
+	imdsProbeMu      sync.Mutex
+	imdsProbed       bool
+	imdsProbeErr     error
+	msiPlatformMu    sync.Mutex
+	msiPlatform      *identityPlatform
+	certificateChain []*x509.Certificate
+	privateKey       *rsa.PrivateKey
+	sendX5C          bool
+	adfs             bool
+	b2cTenant        string
+	b2cPolicy        string
+	regional         bool
+	region           string
+	regionOnce       sync.Once
+	cae              bool
+	credential       credential
+
+	chainSources  []CredentialSource
+	chainExclude  []CredentialSource
+	chainMu       sync.Mutex
+	chainSelected credential
+
+	refreshCallbacks []adal.TokenRefreshCallback
+	refreshWithin    time.Duration
+	subscribersMu    sync.RWMutex
+	subscribers      []func(resource string, expiresOn time.Time)
+
+	msalOptions         []confidential.Option
+	msalCache           cache.ExportReplace
+	msalTelemetry       func(MSALTelemetry)
+	noInstanceDiscovery bool
+
+	cacheMu     sync.RWMutex
+	cacheHits   atomic.Uint64
+	cacheMisses atomic.Uint64
+	tokens      map[string]*adal.ServicePrincipalToken
+	inflight    singleflight.Group
+	persist     *persistentCache
+
+	rateInterval time.Duration
+	rateBurst    int
+	breaker      *breaker
+	retryPolicy  *RetryPolicy
+
+	acquireTimeout     time.Duration
+	imdsAcquireTimeout time.Duration
+
+	tokenStore TokenStore
+
+	refreshLead  time.Duration
+	maxStaleness time.Duration
+	ctx          context.Context
+	stop         chan struct{}
+	stopOnce     sync.Once
+	workers      sync.WaitGroup
+	closeMu      sync.Mutex
+	acquisitions sync.WaitGroup
+
+	oboMu       sync.Mutex
+	oboTokens   map[string]*adal.ServicePrincipalToken
+	oboInflight singleflight.Group
+
+	popOnce sync.Once
+	pop     *popKey
+	popErr  error
 }
 
 type Option func(*Config)
 
+// credential produces a token for resource from a source other than the environment.
+// Options selecting a credential source set it on the Config.
+type credential func(c *Config, resource string) (*adal.ServicePrincipalToken, error)
+
 // New fetches and caches environment settings for resource authentication and initializes loggers.
 func New(opts ...Option) (*Config, error) {
 	var err error
@@ -32,15 +134,45 @@ func New(opts ...Option) (*Config, error) {
 	if settings, err = auth.GetSettingsFromEnvironment(); err != nil {
 		return nil, err
 	}
+	if env, err := environmentFile(); err != nil {
+		return nil, err
+	} else if env != nil {
+		settings.Environment = *env
+	}
+	azidentitySettings(&settings)
 
 	c := &Config{
 		userAgent: "azauth",
 		env:       &settings.Environment,
+		settings:  settings,
+		opts:      opts,
+		stop:      make(chan struct{}),
 	}
+	c.readAzidentityEnvironment()
 
 	for _, opt := range opts {
 		opt(c)
 	}
+	if err := errors.Join(c.optionErrs...); err != nil {
+		return nil, err
+	}
+	if c.metadataEndpoint != "" {
+		if err := c.discoverEnvironment(); err != nil {
+			return nil, err
+		}
+	}
+	if c.env == &settings.Environment {
+		// no option selected the cloud.
+		c.detectEnvironment()
+	}
+	c.applyEnvironmentOverrides()
+
+	if err := c.resolveSecretReferences(); err != nil {
+		return nil, err
+	}
+
+	c.startBackgroundRefresh()
+	c.watchContext()
 
 	return c, nil
 }
@@ -73,22 +205,29 @@ func Tenant(tenant string) Option {
 	}
 }
 
-// AuthorizeClientForResource tries to fetch an authorizer using GetAuthorizerForResource and inject it into a client.
-func (c *Config) AuthorizeClientForResource(client *autorest.Client, resource string) (err error) {
-	if authorizer, err := auth.NewAuthorizerFromEnvironmentWithResource(resource); err == nil {
-		client.Authorizer = authorizer
-		return client.AddToUserAgent(c.userAgent)
+// GetAuthorizerForResource fetches an authorizer for resource from the configured credential source,
+// falling back to the credential chain when no credential source was selected.
+// Authorizers for the same resource share a cached token.
+func (c *Config) GetAuthorizerForResource(resource string) (autorest.Authorizer, error) {
+	spt, err := c.cachedToken(resource)
+	if err != nil {
+		return nil, err
 	}
-	return
+	return c.bearer(spt), nil
 }
 
-// AuthorizeClienet tries to fetch an authorizer for management operations.
-func (c *Config) AuthorizeClient(client *autorest.Client) (err error) {
-	if authorizer, err := auth.NewAuthorizerFromEnvironment(); err == nil {
-		client.Authorizer = authorizer
-		return client.AddToUserAgent(c.userAgent)
+// AuthorizeClientForResource tries to fetch an authorizer using GetAuthorizerForResource and inject it into a client.
+func (c *Config) AuthorizeClientForResource(client *autorest.Client, resource string) error {
+	authorizer, err := c.GetAuthorizerForResource(resource)
+	if err != nil {
+		return err
 	}
-	return
+	return c.inject(client, authorizer)
+}
+
+// AuthorizeClient tries to fetch an authorizer for management operations.
+func (c *Config) AuthorizeClient(client *autorest.Client) error {
+	return c.AuthorizeClientForResource(client, c.armResource())
 }
 
 // AuthorizeClientFromFile tries to fetch an authorizer using GetFileAuthorizer and inject it into a client.
@@ -109,33 +248,197 @@ func (c *Config) AuthorizeClientFromFileForResource(client *autorest.Client, res
 	return
 }
 
-func (c *Config) GetAuthorizerFromArgs() (autorest.Authorizer, error) {
+// WithClientSecret selects client secret credentials as the credential source, so applications that
+// fetch credentials from their own secret store don't need to mutate process environment variables.
+func WithClientSecret(tenantID, clientID, secret string) Option {
+	return func(c *Config) {
+		c.tenant = tenantID
+		c.app = clientID
+		c.key = secret
+		c.credential = clientSecretCredential
+	}
+}
+
+func clientSecretCredential(c *Config, resource string) (*adal.ServicePrincipalToken, error) {
 	if err := c.validateArgs(); err != nil {
 		return nil, err
 	}
-	authConfig := auth.ClientCredentialsConfig{
-		ClientID:     c.app,
-		ClientSecret: c.key,
-		TenantID:     c.tenant,
-		Resource:     c.env.ResourceManagerEndpoint,
-		AADEndpoint:  c.env.ActiveDirectoryEndpoint,
+	return c.secretToken(c.key, resource)
+}
+
+// secretToken authenticates the Config's client ID with secret.
+func (c *Config) secretToken(secret, resource string) (*adal.ServicePrincipalToken, error) {
+	if c.usesMSAL() {
+		cred, err := confidential.NewCredFromSecret(secret)
+		if err != nil {
+			return nil, err
+		}
+		return c.msalCredential(cred, resource)
+	}
+	oauthConfig, err := c.confidentialOAuthConfig()
+	if err != nil {
+		return nil, err
+	}
+	if isV2Endpoint(oauthConfig.TokenEndpoint) || c.cae {
+		// adal only speaks the v1 protocol and cannot request claims, so request those tokens directly.
+		return newCustomToken(oauthConfig.TokenEndpoint, resource, func(ctx context.Context, resource string) (*adal.Token, error) {
+			return c.requestToken(ctx, *oauthConfig, url.Values{
+				"grant_type":    {"client_credentials"},
+				"client_id":     {c.clientID()},
+				"client_secret": {secret},
+				"resource":      {resource},
+			})
+		})
 	}
+	return adal.NewServicePrincipalToken(*oauthConfig, c.clientID(), secret, resource)
+}
+
+// sourceArgs names the app, key, and tenant options as a credential source, keeping their tokens apart from
+// those of the configured source.
+const sourceArgs CredentialSource = "args"
 
-	return authConfig.Authorizer()
+// GetAuthorizerFromArgs fetches an authorizer for management operations using the app, key, and tenant options.
+// Authorizers for the same resource share a cached token.
+func (c *Config) GetAuthorizerFromArgs() (autorest.Authorizer, error) {
+	return c.authorizerFrom(sourceArgs, c.armResource())
 }
 
-// AuthorizeClientFromArgs tries to fetch an authorizer using GetArgsAuthorizer and inject it into a client.
-func (c *Config) AuthorizeClientFromArgs(client *autorest.Client) (err error) {
-	return c.AuthorizeClientFromArgsForResource(client, c.env.ResourceManagerEndpoint)
+// AuthorizeClientFromArgs tries to fetch an authorizer using GetAuthorizerFromArgs and inject it into a client.
+func (c *Config) AuthorizeClientFromArgs(client *autorest.Client) error {
+	return c.AuthorizeClientFromArgsForResource(client, c.armResource())
 }
 
-// AuthorizeClientFromArgs tries to fetch an authorizer using GetArgsAuthorizer and inject it into a client.
-func (c *Config) AuthorizeClientFromArgsForResource(client *autorest.Client, resource string) (err error) {
-	if authorizer, err := c.GetAuthorizerFromArgs(); err == nil {
-		client.Authorizer = authorizer
-		return client.AddToUserAgent(c.userAgent)
+// AuthorizeClientFromArgsForResource tries to fetch an authorizer for resource using the app, key, and tenant options and inject it into a client.
+func (c *Config) AuthorizeClientFromArgsForResource(client *autorest.Client, resource string) error {
+	authorizer, err := c.authorizerFrom(sourceArgs, resource)
+	if err != nil {
+		return err
 	}
-	return
+	return c.inject(client, authorizer)
+}
+
+// source returns the configured credential source, or the credential chain when none was selected.
+// The chain bounds each of its sources itself.
+func (c *Config) source() credential {
+	if c.credential != nil {
+		return c.bounded(c.credential, c.acquireTimeout)
+	}
+	return chainCredential
+}
+
+// sourceCredential returns the credential of source, the configured credential source when unnamed.
+func (c *Config) sourceCredential(source CredentialSource) credential {
+	switch source {
+	case SourceManagedIdentity:
+		return managedIdentityCredential
+	case sourceArgs:
+		return c.bounded(clientSecretCredential, c.acquireTimeout)
+	}
+	return c.source()
+}
+
+// authorizerFrom fetches the cached token of source for resource and wraps it in a bearer authorizer.
+// The token is subject to the same caching and resilience policies as the configured source's.
+func (c *Config) authorizerFrom(source CredentialSource, resource string) (autorest.Authorizer, error) {
+	spt, err := c.cachedSourceToken(source, resource)
+	if err != nil {
+		return nil, err
+	}
+	return c.bearer(spt), nil
+}
+
+// tokenFrom fetches a token for resource from cred and applies the configured refresh window and callbacks.
+func (c *Config) tokenFrom(cred credential, resource string) (*adal.ServicePrincipalToken, error) {
+	if c.closed() {
+		return nil, errClosed
+	}
+	if err := c.validateAudience(resource); err != nil {
+		return nil, err
+	}
+	spt, err := c.tracked(cred)(c, resource)
+	if err != nil {
+		return nil, err
+	}
+	callbacks := append([]adal.TokenRefreshCallback{c.notifyRefresh(resource)}, c.refreshCallbacks...)
+	spt.SetRefreshCallbacks(callbacks)
+	spt.SetRefreshWithin(c.refreshWindow())
+	return spt, nil
+}
+
+// inject sets authorizer on client and appends the configured user agent.
+// With CAE enabled, the client's sender is decorated to answer claims challenges.
+func (c *Config) inject(client *autorest.Client, authorizer autorest.Authorizer) error {
+	client.Authorizer = authorizer
+	if _, bearer := authorizer.(*autorest.BearerAuthorizer); bearer && c.cae {
+		if spt, ok := ServicePrincipalToken(authorizer); ok {
+			sender := client.Sender
+			if sender == nil {
+				sender = autorest.CreateSender()
+			}
+			client.Sender = autorest.DecorateSender(sender, withClaimsChallenge(spt))
+		}
+	}
+	return client.AddToUserAgent(c.userAgent)
+}
+
+// Reload re-reads the AZURE_* credential settings from the environment, which are otherwise read once by New,
+// and flushes cached tokens so they are acquired with the new settings. The cloud environment is not reloaded.
+func (c *Config) Reload() error {
+	settings, err := auth.GetSettingsFromEnvironment()
+	if err != nil {
+		return err
+	}
+	azidentitySettings(&settings)
+	if c.armEnvironment {
+		armEnvironmentSettings(&settings)
+	}
+	c.settingsMu.Lock()
+	c.settings.Values = settings.Values
+	c.settingsMu.Unlock()
+	c.Flush()
+	return nil
+}
+
+// setting returns the environment setting name as read by New or Reload.
+func (c *Config) setting(name string) string {
+	c.settingsMu.RLock()
+	defer c.settingsMu.RUnlock()
+	return c.settings.Values[name]
+}
+
+// environmentSettings returns a copy of the environment settings read by New or Reload.
+func (c *Config) environmentSettings() auth.EnvironmentSettings {
+	c.settingsMu.RLock()
+	defer c.settingsMu.RUnlock()
+	settings := auth.EnvironmentSettings{Environment: *c.env, Values: map[string]string{}}
+	for k, v := range c.settings.Values {
+		settings.Values[k] = v
+	}
+	return settings
+}
+
+// clientID returns the client ID set through options, falling back to AZURE_CLIENT_ID.
+func (c *Config) clientID() string {
+	if c.app != "" {
+		return c.app
+	}
+	return c.setting(auth.ClientID)
+}
+
+// clientSecret returns the client secret set through options, falling back to AZURE_CLIENT_SECRET.
+func (c *Config) clientSecret() string {
+	if c.key != "" {
+		return c.key
+	}
+	return c.setting(auth.ClientSecret)
+}
+
+// tenantID returns the tenant ID set through options, falling back to AZURE_TENANT_ID.
+func (c *Config) tenantID() string {
+	if c.tenant != "" {
+		return c.tenant
+	}
+	return c.setting(auth.TenantID)
 }
 
 func (c *Config) validateArgs() error {
@@ -0,0 +1,108 @@
+package azauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/adal"
+)
+
+// TokenCredential returns an azcore.TokenCredential backed by the Config's credential source,
+// so the same configuration drives Track 2 SDK clients alongside autorest clients.
+// Scopes are mapped back to resources, and claims requested by the SDK are sent with the token request.
+// Tokens for tenants other than the configured one are only acquired when allowed with WithAdditionallyAllowedTenants.
+func (c *Config) TokenCredential() azcore.TokenCredential {
+	return &tokenCredential{c: c}
+}
+
+// tokenCredential adapts a Config to azcore.TokenCredential, sharing the Config's cached tokens.
+type tokenCredential struct {
+	c *Config
+}
+
+// GetToken implements azcore.TokenCredential.
+func (t *tokenCredential) GetToken(ctx context.Context, opts policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	if len(opts.Scopes) != 1 {
+		return azcore.AccessToken{}, errors.New("exactly one scope must be requested")
+	}
+	c, err := t.c.forTenant(opts.TenantID)
+	if err != nil {
+		return azcore.AccessToken{}, err
+	}
+	spt, err := c.cachedToken(resourceForScope(opts.Scopes[0]))
+	if err != nil {
+		return azcore.AccessToken{}, err
+	}
+
+	if opts.Claims != "" {
+		err = spt.RefreshWithContext(context.WithValue(ctx, claimsKey{}, opts.Claims))
+	} else {
+		err = spt.EnsureFreshWithContext(ctx)
+	}
+	if err != nil {
+		return azcore.AccessToken{}, err
+	}
+	token := spt.Token()
+	return azcore.AccessToken{Token: token.AccessToken, ExpiresOn: token.Expires()}, nil
+}
+
+// ARMClientOptions returns client options for Track 2 ARM clients matching the Config: the cloud configuration
+// of its environment, its user agent as the telemetry application ID, and as many retries as autorest clients make.
+// Use it with TokenCredential to construct clients in one line.
+func (c *Config) ARMClientOptions() *arm.ClientOptions {
+	return &arm.ClientOptions{
+		ClientOptions: policy.ClientOptions{
+			Cloud: cloud.Configuration{
+				ActiveDirectoryAuthorityHost: c.env.ActiveDirectoryEndpoint,
+				Services: map[cloud.ServiceName]cloud.ServiceConfiguration{
+					cloud.ResourceManager: {
+						Audience: c.env.TokenAudience,
+						Endpoint: c.env.ResourceManagerEndpoint,
+					},
+				},
+			},
+			Telemetry: policy.TelemetryOptions{ApplicationID: c.userAgent},
+			Retry:     policy.RetryOptions{MaxRetries: int32(autorest.DefaultRetryAttempts)},
+		},
+	}
+}
+
+// WithTokenCredential selects an azcore.TokenCredential, such as an azidentity credential, as the credential
+// source, so Track 2 credentials can authorize autorest clients. Resources are requested as their /.default scope.
+func WithTokenCredential(cred azcore.TokenCredential) Option {
+	return func(c *Config) {
+		c.credential = func(c *Config, resource string) (*adal.ServicePrincipalToken, error) {
+			return newCustomToken(url.URL{Scheme: "azcore"}, resource, func(ctx context.Context, resource string) (*adal.Token, error) {
+				claims, _ := ctx.Value(claimsKey{}).(string)
+				token, err := cred.GetToken(ctx, policy.TokenRequestOptions{
+					Scopes:    []string{scopeForResource(resource)},
+					Claims:    claims,
+					EnableCAE: c.cae,
+				})
+				if err != nil {
+					return nil, err
+				}
+				return &adal.Token{
+					AccessToken: token.Token,
+					ExpiresOn:   json.Number(strconv.FormatInt(token.ExpiresOn.Unix(), 10)),
+					Resource:    resource,
+					Type:        "Bearer",
+				}, nil
+			})
+		}
+	}
+}
+
+// resourceForScope converts an AAD v2 default scope into the equivalent v1 resource.
+func resourceForScope(scope string) string {
+	return strings.TrimSuffix(scope, "/.default")
+}
@@ -0,0 +1,77 @@
+package azauth
+
+import (
+	"context"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/adal"
+	"github.com/Azure/go-autorest/logger"
+)
+
+// backgroundRefreshInterval is how often the background refresher looks for tokens nearing expiry.
+const backgroundRefreshInterval = 30 * time.Second
+
+// WithBackgroundRefresh renews cached tokens in the background once they are within lead of expiring,
+// so requests never wait on a token round trip. lead should exceed the refresh window set with
+// WithRefreshWindow, or requests will refresh tokens before the background refresher does, and stay shorter
+// than the lifetime of issued tokens, or every pass renews them. Failed renewals are logged and retried on
+// the next pass.
+func WithBackgroundRefresh(lead time.Duration) Option {
+	return func(c *Config) {
+		c.refreshLead = lead
+	}
+}
+
+// startBackgroundRefresh starts the background refresher when enabled.
+func (c *Config) startBackgroundRefresh() {
+	if c.refreshLead <= 0 {
+		return
+	}
+	c.workers.Add(1)
+	go func() {
+		defer c.workers.Done()
+		ticker := time.NewTicker(backgroundRefreshInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-c.stop:
+				return
+			case <-ticker.C:
+				c.refreshExpiring()
+			}
+		}
+	}()
+}
+
+// refreshExpiring renews the cached tokens that were acquired and expire within the refresh lead.
+func (c *Config) refreshExpiring() {
+	c.cacheMu.RLock()
+	var expiring []*adal.ServicePrincipalToken
+	for _, spt := range c.tokens {
+		if token := spt.Token(); token.AccessToken != "" && token.WillExpireIn(c.refreshLead) {
+			expiring = append(expiring, spt)
+		}
+	}
+	c.cacheMu.RUnlock()
+
+	for _, spt := range expiring {
+		ctx, cancel := context.WithTimeout(context.WithValue(context.Background(), refreshLeadKey{}, c.refreshLead), backgroundRefreshInterval)
+		if err := spt.RefreshWithContext(ctx); err != nil {
+			logger.Instance.Writef(logger.LogWarning, "azauth: background token refresh failed: %v\n", err)
+		}
+		cancel()
+	}
+}
+
+// refreshLeadKey carries the refresh lead of background refreshes, which renew tokens the refresh window
+// would still consider fresh.
+type refreshLeadKey struct{}
+
+// dueWithin returns how long before expiry a token is renewed by the refresh ctx belongs to, so the layers
+// that keep tokens, such as MSAL's cache and the persistent cache, don't hand back the token being renewed.
+func (c *Config) dueWithin(ctx context.Context) time.Duration {
+	if lead, ok := ctx.Value(refreshLeadKey{}).(time.Duration); ok && lead > c.refreshWindow() {
+		return lead
+	}
+	return c.refreshWindow()
+}
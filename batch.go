@@ -0,0 +1,20 @@
+package azauth
+
+import "github.com/Azure/go-autorest/autorest"
+
+// BatchResource returns the audience of Azure Batch data plane tokens in the Config's cloud, e.g.
+// https://batch.core.windows.net/ in the public cloud and https://batch.core.usgovcloudapi.net/ in US
+// Government, rather than the endpoint of the Batch account clients send requests to.
+func (c *Config) BatchResource() (string, error) {
+	return c.serviceResource("Azure Batch", c.env.ResourceIdentifiers.Batch)
+}
+
+// AuthorizeBatchClient authorizes client, the autorest.Client of a Batch data plane client, for BatchResource.
+// Management clients of Batch accounts are authorized with AuthorizeClient like other management clients.
+func (c *Config) AuthorizeBatchClient(client *autorest.Client) error {
+	resource, err := c.BatchResource()
+	if err != nil {
+		return err
+	}
+	return c.AuthorizeClientForResource(client, resource)
+}
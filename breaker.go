@@ -0,0 +1,111 @@
+package azauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/adal"
+)
+
+// CircuitOpenError is returned without contacting the token service while the circuit breaker set with
+// WithCircuitBreaker is open.
+type CircuitOpenError struct {
+	// Until is when the next acquisition will be attempted.
+	Until time.Time
+	// Err is the failure that opened the circuit.
+	Err error
+}
+
+// Error implements error.
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("token acquisition is suspended until %s after repeated failures: %v", e.Until.Format(time.RFC3339), e.Err)
+}
+
+// Unwrap returns the failure that opened the circuit.
+func (e *CircuitOpenError) Unwrap() error {
+	return e.Err
+}
+
+// WithCircuitBreaker stops contacting the token service of the credential source, IMDS for managed identity
+// and AAD otherwise, for cooldown after failures consecutive acquisitions fail. Meanwhile acquisitions fail
+// fast with a *CircuitOpenError rather than every caller waiting out its own timeouts. Once cooldown has
+// passed, one acquisition is let through: its success closes the circuit and its failure reopens it.
+func WithCircuitBreaker(failures int, cooldown time.Duration) Option {
+	return func(c *Config) {
+		c.breaker = &breaker{threshold: failures, cooldown: cooldown}
+	}
+}
+
+// breaker tracks consecutive acquisition failures across every resource of a Config.
+type breaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	openUntil time.Time
+	probing   bool
+	lastErr   error
+}
+
+// allow reports whether an acquisition may proceed, returning a *CircuitOpenError if not.
+func (b *breaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures < b.threshold {
+		return nil
+	}
+	if time.Now().Before(b.openUntil) || b.probing {
+		return &CircuitOpenError{Until: b.openUntil, Err: b.lastErr}
+	}
+	b.probing = true
+	return nil
+}
+
+// done records the outcome of an acquisition allowed by allow.
+func (b *breaker) done(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+	if errors.Is(err, context.Canceled) {
+		// the caller gave up, which says nothing about the token service.
+		return
+	}
+	if err == nil {
+		b.failures, b.lastErr = 0, nil
+		return
+	}
+	b.failures++
+	b.lastErr = err
+	if b.failures >= b.threshold {
+		b.openUntil = time.Now().Add(b.cooldown)
+	}
+}
+
+// guarded wraps cred so its refreshes go through the circuit breaker, when one is configured.
+func (c *Config) guarded(cred credential) credential {
+	if c.breaker == nil || c.breaker.threshold <= 0 {
+		return cred
+	}
+	return func(c *Config, resource string) (*adal.ServicePrincipalToken, error) {
+		inner, err := cred(c, resource)
+		if err != nil {
+			return nil, err
+		}
+		return newCustomToken(url.URL{Scheme: "breaker"}, resource, func(ctx context.Context, resource string) (*adal.Token, error) {
+			if err := c.breaker.allow(); err != nil {
+				return nil, err
+			}
+			err := inner.RefreshWithContext(ctx)
+			c.breaker.done(err)
+			if err != nil {
+				return nil, err
+			}
+			token := inner.Token()
+			return &token, nil
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package azauth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	errUnavailable := errors.New("token service unavailable")
+	for _, tc := range []struct {
+		name string
+		// outcomes are the results of acquisitions the breaker allowed, in order.
+		outcomes []error
+		// cooledDown expires the cooldown before the final allow.
+		cooledDown bool
+		// probing starts an acquisition before the final allow.
+		probing  bool
+		wantOpen bool
+	}{
+		{name: "closed", outcomes: []error{errUnavailable}},
+		{name: "opens at the threshold", outcomes: []error{errUnavailable, errUnavailable}, wantOpen: true},
+		{name: "success resets failures", outcomes: []error{errUnavailable, nil, errUnavailable}},
+		{name: "cancellation isn't a failure", outcomes: []error{errUnavailable, context.Canceled}},
+		{name: "probes after cooldown", outcomes: []error{errUnavailable, errUnavailable}, cooledDown: true},
+		{name: "one probe at a time", outcomes: []error{errUnavailable, errUnavailable}, cooledDown: true, probing: true, wantOpen: true},
+		{name: "failed probe reopens", outcomes: []error{errUnavailable, errUnavailable, errUnavailable}, wantOpen: true},
+		{name: "successful probe closes", outcomes: []error{errUnavailable, errUnavailable, nil}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			b := &breaker{threshold: 2, cooldown: time.Hour}
+			for i, outcome := range tc.outcomes {
+				if i >= b.threshold {
+					// acquisitions past the threshold are probes let through after the cooldown.
+					b.openUntil = time.Now()
+				}
+				if err := b.allow(); err != nil {
+					t.Fatalf("acquisition %d wasn't allowed: %v", i+1, err)
+				}
+				b.done(outcome)
+			}
+			if tc.cooledDown {
+				b.openUntil = time.Now()
+			}
+			if tc.probing {
+				if err := b.allow(); err != nil {
+					t.Fatalf("the probe wasn't allowed: %v", err)
+				}
+			}
+
+			err := b.allow()
+			var open *CircuitOpenError
+			if got := errors.As(err, &open); got != tc.wantOpen {
+				t.Fatalf("allow() = %v, want a *CircuitOpenError: %t", err, tc.wantOpen)
+			}
+			if tc.wantOpen && !errors.Is(err, errUnavailable) {
+				t.Errorf("allow() = %v, want it to wrap the failure that opened the circuit", err)
+			}
+		})
+	}
+}
+
+func TestBreakerGuardsManagedIdentity(t *testing.T) {
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		http.Error(w, `{"error":"invalid_request"}`, http.StatusBadRequest)
+	}))
+	defer srv.Close()
+	c := newTestConfig(t, WithIMDSEndpoint(srv.URL), WithCircuitBreaker(2, time.Hour))
+
+	var err error
+	for i := 0; i < 3; i++ {
+		authorizer, aerr := c.GetAuthorizerFromMSI("https://resource.example.com")
+		if aerr != nil {
+			t.Fatal(aerr)
+		}
+		spt, _ := ServicePrincipalToken(authorizer)
+		if err = spt.Refresh(); err == nil {
+			t.Fatal("Refresh() succeeded against a failing identity endpoint")
+		}
+	}
+	var open *CircuitOpenError
+	if !errors.As(err, &open) {
+		t.Errorf("Refresh() = %v, want a *CircuitOpenError", err)
+	}
+	if got := atomic.LoadInt32(&requests); got != 2 {
+		t.Errorf("identity endpoint requests = %d, want 2", got)
+	}
+}
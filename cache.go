@@ -0,0 +1,216 @@
+package azauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/adal"
+	"github.com/Azure/go-autorest/logger"
+)
+
+// CacheStats describes the use of a Config's token cache.
+type CacheStats struct {
+	// Hits counts token lookups served by a cached token.
+	Hits uint64
+	// Misses counts token lookups that created a token.
+	Misses uint64
+	// Tokens is the number of cached tokens.
+	Tokens int
+}
+
+// CacheStats returns the Config's token cache statistics.
+func (c *Config) CacheStats() CacheStats {
+	c.cacheMu.RLock()
+	defer c.cacheMu.RUnlock()
+	return CacheStats{
+		Hits:   c.cacheHits.Load(),
+		Misses: c.cacheMisses.Load(),
+		Tokens: len(c.tokens),
+	}
+}
+
+// TokenExpiry returns when the cached token for resource expires. It reports false when no token has been
+// acquired for resource yet. An expiry in the past means refreshes have been failing.
+func (c *Config) TokenExpiry(resource string) (time.Time, bool) {
+	c.cacheMu.RLock()
+	spt, ok := c.tokens[c.cacheKey("", resource)]
+	c.cacheMu.RUnlock()
+	if !ok {
+		return time.Time{}, false
+	}
+	token := spt.Token()
+	if token.AccessToken == "" {
+		return time.Time{}, false
+	}
+	return token.Expires(), true
+}
+
+// NextExpiry returns the resource whose cached token expires first, and when. It reports false when no
+// token has been acquired. Alerting when the expiry draws close detects a stalled refresh for any resource.
+func (c *Config) NextExpiry() (string, time.Time, bool) {
+	c.cacheMu.RLock()
+	defer c.cacheMu.RUnlock()
+	var resource string
+	var next time.Time
+	for key, spt := range c.tokens {
+		token := spt.Token()
+		if token.AccessToken == "" {
+			continue
+		}
+		if expires := token.Expires(); next.IsZero() || expires.Before(next) {
+			resource, next = key[strings.Index(key, "|")+1:], expires
+		}
+	}
+	return resource, next, !next.IsZero()
+}
+
+// Invalidate drops the cached tokens for resource, including those of GetAuthorizerFromMSI and
+// GetAuthorizerFromArgs and any persisted copies, so the next lookup authenticates anew with the current
+// credentials, e.g. after a secret was rotated. Clients authorized earlier keep the token they hold until
+// they are authorized again.
+func (c *Config) Invalidate(resource string) {
+	sources := []CredentialSource{"", SourceManagedIdentity, sourceArgs}
+	c.cacheMu.Lock()
+	for _, source := range sources {
+		delete(c.tokens, c.cacheKey(source, resource))
+	}
+	c.cacheMu.Unlock()
+	c.forgetPersisted(func(key string) bool {
+		for _, source := range sources {
+			if key == c.persistKey(source, resource) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// Flush drops every cached token, including on-behalf-of tokens, tokens of other tenants, and persisted copies, and forgets the
+// credential source a chain selected, the managed identity platform, and whether IMDS is available, so the next lookups
+// authenticate anew. Clients authorized earlier keep the token they hold until they are authorized again.
+func (c *Config) Flush() {
+	c.cacheMu.Lock()
+	c.tokens = nil
+	c.cacheMu.Unlock()
+
+	c.oboMu.Lock()
+	c.oboTokens = nil
+	c.oboMu.Unlock()
+
+	c.chainMu.Lock()
+	c.chainSelected = nil
+	c.chainMu.Unlock()
+
+	c.imdsProbeMu.Lock()
+	c.imdsProbed = false
+	c.imdsProbeMu.Unlock()
+
+	c.msiPlatformMu.Lock()
+	c.msiPlatform = nil
+	c.msiPlatformMu.Unlock()
+
+	c.closeTenants()
+
+	// keys end with the source and resource, so the key for neither prefixes every key of the identity.
+	prefix := c.persistKey("", "")
+	c.forgetPersisted(func(key string) bool { return strings.HasPrefix(key, prefix) })
+}
+
+// forgetPersisted removes the persisted tokens whose keys match, if tokens are persisted.
+func (c *Config) forgetPersisted(match func(key string) bool) {
+	if c.persist == nil {
+		return
+	}
+	if err := c.persist.remove(context.Background(), match); err != nil {
+		logger.Instance.Writef(logger.LogWarning, "azauth: failed to remove tokens from the persistent token cache: %v\n", err)
+	}
+}
+
+// Warmup acquires and caches tokens for resources concurrently, so misconfiguration surfaces at startup
+// and the first requests don't wait on token acquisition. It returns the failures of all resources joined.
+func (c *Config) Warmup(ctx context.Context, resources ...string) error {
+	errs := make([]error, len(resources))
+	var wg sync.WaitGroup
+	for i, resource := range resources {
+		wg.Add(1)
+		go func(i int, resource string) {
+			defer wg.Done()
+			spt, err := c.cachedToken(resource)
+			if err == nil {
+				err = spt.EnsureFreshWithContext(ctx)
+			}
+			if err != nil {
+				errs[i] = fmt.Errorf("%s: %w", resource, err)
+			}
+		}(i, resource)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// wrap layers the configured caching and resilience policies around cred, the credential of source. From the
+// outside in, persisted tokens are served first, then the rate limit applies, then the circuit breaker, and
+// retries are innermost, so a retried acquisition counts once towards the limit and the breaker.
+func (c *Config) wrap(source CredentialSource, cred credential) credential {
+	return c.persisted(source, c.limited(c.guarded(c.retried(cred))))
+}
+
+// cachedToken returns the token for resource from the configured credential source, creating it on first use.
+// Tokens are cached per tenant and resource, so every client authorized for a resource shares one token and
+// its refreshes instead of each acquiring their own. Concurrent first uses of a resource wait on a single
+// creation, and concurrent refreshes of a shared token are collapsed by the token itself.
+func (c *Config) cachedToken(resource string) (*adal.ServicePrincipalToken, error) {
+	return c.cachedSourceToken("", resource)
+}
+
+// cachedSourceToken returns the token for resource from source, cached like the configured source's tokens
+// but apart from them. The configured source is unnamed.
+func (c *Config) cachedSourceToken(source CredentialSource, resource string) (*adal.ServicePrincipalToken, error) {
+	key := c.cacheKey(source, resource)
+
+	c.cacheMu.RLock()
+	spt, ok := c.tokens[key]
+	c.cacheMu.RUnlock()
+	if ok {
+		c.cacheHits.Add(1)
+		return spt, nil
+	}
+
+	v, err, _ := c.inflight.Do(key, func() (interface{}, error) {
+		c.cacheMu.RLock()
+		spt, ok := c.tokens[key]
+		c.cacheMu.RUnlock()
+		if ok {
+			return spt, nil
+		}
+		c.cacheMisses.Add(1)
+		spt, err := c.tokenFrom(c.wrap(source, c.sourceCredential(source)), resource)
+		if err != nil {
+			return nil, err
+		}
+		c.cacheMu.Lock()
+		defer c.cacheMu.Unlock()
+		if c.tokens == nil {
+			c.tokens = map[string]*adal.ServicePrincipalToken{}
+		}
+		c.tokens[key] = spt
+		return spt, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*adal.ServicePrincipalToken), nil
+}
+
+// cacheKey identifies the token of source for resource in the token cache.
+func (c *Config) cacheKey(source CredentialSource, resource string) string {
+	key := c.tenantID() + "|" + resource
+	if source != "" {
+		key = string(source) + ":" + key
+	}
+	return key
+}
@@ -0,0 +1,72 @@
+package azauth
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+)
+
+func TestExplicitSourcesShareTokens(t *testing.T) {
+	var imdsRequests int32
+	imds := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&imdsRequests, 1)
+		w.Header().Set("Content-Type", "application/json")
+		// IMDS reports the expiry in expires_on, which adal relies on.
+		fmt.Fprintf(w, `{"access_token":"token","token_type":"Bearer","expires_in":"3600","expires_on":"%d"}`, time.Now().Add(time.Hour).Unix())
+	}))
+	defer imds.Close()
+	aad := newAADServer(t)
+
+	for _, tc := range []struct {
+		name      string
+		opts      []Option
+		authorize func(c *Config, client *autorest.Client) error
+		requests  func() int
+	}{
+		{
+			name: "managed identity",
+			opts: []Option{WithIMDSEndpoint(imds.URL)},
+			authorize: func(c *Config, client *autorest.Client) error {
+				return c.AuthorizeClientFromMSIForResource(client, "https://resource.example.com")
+			},
+			requests: func() int { return int(atomic.LoadInt32(&imdsRequests)) },
+		},
+		{
+			name: "args",
+			opts: append(aad.options(), App("client"), Key("secret"), Tenant("tenant")),
+			authorize: func(c *Config, client *autorest.Client) error {
+				return c.AuthorizeClientFromArgsForResource(client, "https://resource.example.com")
+			},
+			requests: aad.tokenRequests,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := newTestConfig(t, tc.opts...)
+			before := tc.requests()
+			for i := 0; i < 3; i++ {
+				var client autorest.Client
+				if err := tc.authorize(c, &client); err != nil {
+					t.Fatal(err)
+				}
+				spt, ok := ServicePrincipalToken(client.Authorizer)
+				if !ok {
+					t.Fatal("the client wasn't authorized with a token")
+				}
+				if err := spt.EnsureFresh(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := tc.requests() - before; got != 1 {
+				t.Errorf("token requests = %d, want 1", got)
+			}
+			if stats := c.CacheStats(); stats.Tokens != 1 || stats.Hits != 2 {
+				t.Errorf("CacheStats() = %+v, want 1 token and 2 hits", stats)
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package azauth
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/adal"
+)
+
+// caeCapability declares that the client handles claims challenges, so AAD issues CAE-enabled tokens.
+const caeCapability = `{"access_token":{"xms_cc":{"values":["cp1"]}}}`
+
+// challengeParam matches the auth-params of a WWW-Authenticate header.
+var challengeParam = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// claimsKey carries the claims of a challenge to the token request it triggers.
+type claimsKey struct{}
+
+// WithCAE enables Continuous Access Evaluation. Token requests declare the cp1 client capability, and clients
+// authorized through the Config transparently handle claims challenges: when a request fails with 401 and an
+// insufficient_claims challenge, a token carrying the requested claims is acquired and the request is retried once.
+// Claims are only sent by credential sources that request tokens from AAD, such as client secrets,
+// certificates, federated assertions, and user sign in; other sources re-acquire a token without them.
+func WithCAE() Option {
+	return func(c *Config) {
+		c.cae = true
+	}
+}
+
+// tokenClaims returns the claims parameter of a token request made with ctx.
+func (c *Config) tokenClaims(ctx context.Context) (string, error) {
+	challenge, _ := ctx.Value(claimsKey{}).(string)
+	if !c.cae {
+		return challenge, nil
+	}
+	if challenge == "" {
+		return caeCapability, nil
+	}
+	return mergeClaims(caeCapability, challenge)
+}
+
+// mergeClaims merges two claims request objects.
+func mergeClaims(a, b string) (string, error) {
+	var x, y map[string]interface{}
+	if err := json.Unmarshal([]byte(a), &x); err != nil {
+		return "", err
+	}
+	if err := json.Unmarshal([]byte(b), &y); err != nil {
+		return "", err
+	}
+	merged, err := json.Marshal(mergeObjects(x, y))
+	if err != nil {
+		return "", err
+	}
+	return string(merged), nil
+}
+
+// mergeObjects merges src into dst recursively, with src winning conflicts.
+func mergeObjects(dst, src map[string]interface{}) map[string]interface{} {
+	for k, v := range src {
+		if sub, ok := v.(map[string]interface{}); ok {
+			if existing, ok := dst[k].(map[string]interface{}); ok {
+				dst[k] = mergeObjects(existing, sub)
+				continue
+			}
+		}
+		dst[k] = v
+	}
+	return dst
+}
+
+// claimsChallenge returns the decoded claims of an insufficient_claims challenge in resp, if any.
+func claimsChallenge(resp *http.Response) string {
+	if resp.StatusCode != http.StatusUnauthorized {
+		return ""
+	}
+	for _, header := range resp.Header[http.CanonicalHeaderKey("WWW-Authenticate")] {
+		params := map[string]string{}
+		for _, m := range challengeParam.FindAllStringSubmatch(header, -1) {
+			params[m[1]] = m[2]
+		}
+		if params["error"] != "insufficient_claims" || params["claims"] == "" {
+			continue
+		}
+		for _, encoding := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+			if claims, err := encoding.DecodeString(params["claims"]); err == nil {
+				return string(claims)
+			}
+		}
+	}
+	return ""
+}
+
+// withClaimsChallenge returns a SendDecorator that answers claims challenges by refreshing spt with the
+// requested claims and retrying the request once with the new token.
+func withClaimsChallenge(spt *adal.ServicePrincipalToken) autorest.SendDecorator {
+	return func(s autorest.Sender) autorest.Sender {
+		return autorest.SenderFunc(func(r *http.Request) (*http.Response, error) {
+			rr := autorest.NewRetriableRequest(r)
+			if err := rr.Prepare(); err != nil {
+				return nil, err
+			}
+			resp, err := s.Do(rr.Request())
+			if err != nil {
+				return resp, err
+			}
+			claims := claimsChallenge(resp)
+			if claims == "" {
+				return resp, nil
+			}
+			if err := spt.RefreshWithContext(context.WithValue(r.Context(), claimsKey{}, claims)); err != nil {
+				return resp, err
+			}
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+			if err := rr.Prepare(); err != nil {
+				return nil, err
+			}
+			retry := rr.Request()
+			retry.Header.Set("Authorization", "Bearer "+spt.OAuthToken())
+			return s.Do(retry)
+		})
+	}
+}
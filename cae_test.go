@@ -0,0 +1,189 @@
+package azauth
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/adal"
+)
+
+func TestMergeClaims(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		a, b    string
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "capability and challenge",
+			a:    caeCapability,
+			b:    `{"access_token":{"nbf":{"essential":true,"value":"1700000000"}}}`,
+			want: `{"access_token":{"nbf":{"essential":true,"value":"1700000000"},"xms_cc":{"values":["cp1"]}}}`,
+		},
+		{
+			name: "challenge wins conflicts",
+			a:    `{"access_token":{"xms_cc":{"values":["cp1"]}}}`,
+			b:    `{"access_token":{"xms_cc":{"values":["cp2"]}}}`,
+			want: `{"access_token":{"xms_cc":{"values":["cp2"]}}}`,
+		},
+		{
+			name: "disjoint tokens",
+			a:    `{"access_token":{"a":1}}`,
+			b:    `{"id_token":{"b":2}}`,
+			want: `{"access_token":{"a":1},"id_token":{"b":2}}`,
+		},
+		{name: "invalid first", a: `{`, b: `{}`, wantErr: true},
+		{name: "invalid second", a: `{}`, b: `[`, wantErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := mergeClaims(tc.a, tc.b)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("mergeClaims() succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var gotValue, wantValue interface{}
+			if err := json.Unmarshal([]byte(got), &gotValue); err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal([]byte(tc.want), &wantValue); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(gotValue, wantValue) {
+				t.Errorf("mergeClaims() = %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestClaimsChallenge(t *testing.T) {
+	const claims = `{"access_token":{"nbf":{"essential":true,"value":"1700000000"}}}`
+	for _, tc := range []struct {
+		name    string
+		status  int
+		headers []string
+		want    string
+	}{
+		{
+			name:    "standard encoding",
+			status:  http.StatusUnauthorized,
+			headers: []string{`Bearer realm="", error="insufficient_claims", claims="` + base64.StdEncoding.EncodeToString([]byte(claims)) + `"`},
+			want:    claims,
+		},
+		{
+			name:    "unpadded URL encoding",
+			status:  http.StatusUnauthorized,
+			headers: []string{`Bearer error="insufficient_claims", claims="` + base64.RawURLEncoding.EncodeToString([]byte(claims)) + `"`},
+			want:    claims,
+		},
+		{
+			name:    "second challenge",
+			status:  http.StatusUnauthorized,
+			headers: []string{`Basic realm="x"`, `Bearer error="insufficient_claims", claims="` + base64.StdEncoding.EncodeToString([]byte(claims)) + `"`},
+			want:    claims,
+		},
+		{
+			name:    "other error",
+			status:  http.StatusUnauthorized,
+			headers: []string{`Bearer error="invalid_token", claims="` + base64.StdEncoding.EncodeToString([]byte(claims)) + `"`},
+		},
+		{
+			name:    "no claims",
+			status:  http.StatusUnauthorized,
+			headers: []string{`Bearer error="insufficient_claims"`},
+		},
+		{
+			name:    "not unauthorized",
+			status:  http.StatusForbidden,
+			headers: []string{`Bearer error="insufficient_claims", claims="` + base64.StdEncoding.EncodeToString([]byte(claims)) + `"`},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			resp := &http.Response{StatusCode: tc.status, Header: http.Header{"Www-Authenticate": tc.headers}}
+			if got := claimsChallenge(resp); got != tc.want {
+				t.Errorf("claimsChallenge() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestWithClaimsChallenge(t *testing.T) {
+	challenge := `Bearer error="insufficient_claims", claims="` + base64.StdEncoding.EncodeToString([]byte(`{"access_token":{}}`)) + `"`
+	for _, tc := range []struct {
+		name         string
+		challenge    string
+		wantStatus   int
+		wantRequests int32
+	}{
+		{name: "no challenge", wantStatus: http.StatusOK, wantRequests: 1},
+		{name: "answered challenge", challenge: challenge, wantStatus: http.StatusOK, wantRequests: 2},
+		{name: "other error", challenge: `Bearer error="invalid_token"`, wantStatus: http.StatusUnauthorized, wantRequests: 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var requests int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&requests, 1)
+				body, _ := ioutil.ReadAll(r.Body)
+				if string(body) != "payload" {
+					http.Error(w, "the body wasn't replayed", http.StatusBadRequest)
+					return
+				}
+				if tc.challenge != "" && r.Header.Get("Authorization") != "Bearer claims-token" {
+					w.Header().Set("WWW-Authenticate", tc.challenge)
+					w.WriteHeader(http.StatusUnauthorized)
+				}
+			}))
+			defer srv.Close()
+
+			spt, err := newCustomToken(url.URL{Scheme: "test"}, "resource", func(ctx context.Context, resource string) (*adal.Token, error) {
+				token := "token"
+				if claims, _ := ctx.Value(claimsKey{}).(string); claims != "" {
+					token = "claims-token"
+				}
+				return &adal.Token{
+					AccessToken: token,
+					ExpiresOn:   json.Number(strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)),
+					Type:        "Bearer",
+				}, nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := spt.Refresh(); err != nil {
+				t.Fatal(err)
+			}
+			req, err := http.NewRequest(http.MethodPost, srv.URL, strings.NewReader("payload"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Authorization", "Bearer "+spt.OAuthToken())
+			resp, err := autorest.DecorateSender(srv.Client(), withClaimsChallenge(spt)).Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tc.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tc.wantStatus)
+			}
+			if got := atomic.LoadInt32(&requests); got != tc.wantRequests {
+				t.Errorf("requests = %d, want %d", got, tc.wantRequests)
+			}
+		})
+	}
+}
@@ -0,0 +1,192 @@
+package azauth
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/adal"
+	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/confidential"
+	"github.com/golang-jwt/jwt/v4"
+	"golang.org/x/crypto/pkcs12"
+)
+
+// WithClientCertificate selects a client certificate as the credential source, authenticating as the
+// client ID and tenant provided with App and Tenant or found in the environment.
+// certificate may be PEM encoded, including bundles with a full chain, or a PKCS#12 (PFX) archive
+// protected by password. Parsing failures fail New.
+func WithClientCertificate(certificate []byte, password string) Option {
+	return func(c *Config) {
+		chain, key, err := parseCertificate(certificate, password)
+		if err != nil {
+			c.optionErrs = append(c.optionErrs, fmt.Errorf("failed to parse the client certificate: %w", err))
+			return
+		}
+		c.certificateChain = chain
+		c.privateKey = key
+		c.credential = certificateCredential
+	}
+}
+
+// WithX5C sends the full certificate chain in the x5c header of client certificate assertions.
+// This enables subject name and issuer (SN+I) authentication, where AAD validates the certificate by
+// its subject and issuing CA rather than a registered thumbprint, so certificates can auto-rotate.
+func WithX5C() Option {
+	return func(c *Config) {
+		c.sendX5C = true
+	}
+}
+
+func certificateCredential(c *Config, resource string) (*adal.ServicePrincipalToken, error) {
+	chain, key := c.clientCertificate()
+	if key == nil {
+		return nil, errClosed
+	}
+	return c.certificateToken(chain, key, resource)
+}
+
+// clientCertificate returns the certificate chain and private key of WithClientCertificate, or a nil key
+// once Close has zeroed it.
+func (c *Config) clientCertificate() ([]*x509.Certificate, *rsa.PrivateKey) {
+	c.settingsMu.RLock()
+	defer c.settingsMu.RUnlock()
+	return c.certificateChain, c.privateKey
+}
+
+// certificateToken authenticates the Config's client ID with the certificate chain and its private key.
+func (c *Config) certificateToken(chain []*x509.Certificate, key *rsa.PrivateKey, resource string) (*adal.ServicePrincipalToken, error) {
+	if c.usesMSAL() {
+		cred, err := confidential.NewCredFromCert(chain, key)
+		if err != nil {
+			return nil, err
+		}
+		return c.msalCredential(cred, resource)
+	}
+	oauthConfig, err := c.confidentialOAuthConfig()
+	if err != nil {
+		return nil, err
+	}
+	if c.cae {
+		// adal cannot request claims, so request CAE tokens directly.
+		return newCustomToken(oauthConfig.TokenEndpoint, resource, func(ctx context.Context, resource string) (*adal.Token, error) {
+			v := url.Values{}
+			v.Set("grant_type", "client_credentials")
+			v.Set("client_id", c.clientID())
+			v.Set("resource", resource)
+			if err := setClientAssertion(v, chain, key, oauthConfig.TokenEndpoint, c.clientID()); err != nil {
+				return nil, err
+			}
+			return c.requestToken(ctx, *oauthConfig, v)
+		})
+	}
+	if c.sendX5C {
+		return c.federatedToken(resource, func() (string, error) {
+			return signAssertion(chain, key, oauthConfig.TokenEndpoint.String(), c.clientID())
+		})
+	}
+	return adal.NewServicePrincipalTokenFromCertificate(*oauthConfig, c.clientID(), chain[0], key, resource)
+}
+
+// signAssertion returns a client assertion for clientID signed by key, with chain in the x5c header.
+func signAssertion(chain []*x509.Certificate, key *rsa.PrivateKey, audience, clientID string) (string, error) {
+	jti := make([]byte, 20)
+	if _, err := rand.Read(jti); err != nil {
+		return "", err
+	}
+	thumbprint := sha1.Sum(chain[0].Raw)
+	x5c := make([]string, len(chain))
+	for i, cert := range chain {
+		x5c[i] = base64.StdEncoding.EncodeToString(cert.Raw)
+	}
+
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"aud": audience,
+		"iss": clientID,
+		"sub": clientID,
+		"jti": base64.RawURLEncoding.EncodeToString(jti),
+		"nbf": now.Unix(),
+		"exp": now.Add(10 * time.Minute).Unix(),
+	})
+	token.Header["x5t"] = base64.RawURLEncoding.EncodeToString(thumbprint[:])
+	token.Header["x5c"] = x5c
+	return token.SignedString(key)
+}
+
+// parseCertificate decodes a PEM bundle or PFX archive into a certificate chain and its RSA private key.
+// The leaf certificate matching the private key is always first in the returned chain.
+func parseCertificate(data []byte, password string) ([]*x509.Certificate, *rsa.PrivateKey, error) {
+	blocks, err := pemBlocks(data, password)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var certs []*x509.Certificate
+	var key *rsa.PrivateKey
+	for _, block := range blocks {
+		switch block.Type {
+		case "CERTIFICATE":
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, nil, err
+			}
+			certs = append(certs, cert)
+		case "RSA PRIVATE KEY":
+			if key, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+				return nil, nil, err
+			}
+		case "PRIVATE KEY":
+			parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+			if err != nil {
+				// pkcs12.ToPEM labels PKCS#1 RSA keys as PRIVATE KEY.
+				if pkcs1, pkcs1Err := x509.ParsePKCS1PrivateKey(block.Bytes); pkcs1Err == nil {
+					parsed, err = pkcs1, nil
+				}
+			}
+			if err != nil {
+				return nil, nil, err
+			}
+			rsaKey, ok := parsed.(*rsa.PrivateKey)
+			if !ok {
+				return nil, nil, fmt.Errorf("unsupported private key type %T, only RSA keys are supported", parsed)
+			}
+			key = rsaKey
+		}
+	}
+	if key == nil {
+		return nil, nil, errors.New("no private key found in client certificate")
+	}
+
+	for i, cert := range certs {
+		if pub, ok := cert.PublicKey.(*rsa.PublicKey); ok && pub.N.Cmp(key.N) == 0 && pub.E == key.E {
+			certs[0], certs[i] = certs[i], certs[0]
+			return certs, key, nil
+		}
+	}
+	return nil, nil, errors.New("no certificate matching the private key found in client certificate")
+}
+
+// pemBlocks returns the PEM blocks in data, converting from PFX if data is not PEM encoded.
+func pemBlocks(data []byte, password string) ([]*pem.Block, error) {
+	if !bytes.Contains(data, []byte("-----BEGIN")) {
+		return pkcs12.ToPEM(data, password)
+	}
+	var blocks []*pem.Block
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return blocks, nil
+		}
+		blocks = append(blocks, block)
+	}
+}
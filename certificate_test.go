@@ -0,0 +1,124 @@
+package azauth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// issuedCertificate returns a PEM encoded certificate for a new RSA key, signed by parentKey as parent, or
+// self-signed when parent is nil, and the new key.
+func issuedCertificate(t *testing.T, name string, parent *x509.Certificate, parentKey *rsa.PrivateKey) ([]byte, *x509.Certificate, *rsa.PrivateKey) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  parent == nil,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), cert, key
+}
+
+func pkcs8PEM(t *testing.T, key interface{}) []byte {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+func TestParseCertificate(t *testing.T) {
+	caPEM, ca, caKey := issuedCertificate(t, "ca", nil, nil)
+	leafPEM, _, leafKey := issuedCertificate(t, "leaf", ca, caKey)
+	leafKeyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(leafKey)})
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pfx, err := os.ReadFile("testdata/client.pfx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	join := func(parts ...[]byte) []byte {
+		var b []byte
+		for _, p := range parts {
+			b = append(b, p...)
+		}
+		return b
+	}
+
+	for _, tc := range []struct {
+		name      string
+		data      []byte
+		password  string
+		wantChain []string
+		wantErr   string
+	}{
+		{name: "PKCS#1 key", data: join(leafPEM, leafKeyPEM), wantChain: []string{"leaf"}},
+		{name: "PKCS#8 key first", data: join(pkcs8PEM(t, leafKey), leafPEM), wantChain: []string{"leaf"}},
+		{name: "chain with the leaf last", data: join(caPEM, leafPEM, leafKeyPEM), wantChain: []string{"leaf", "ca"}},
+		{name: "PKCS#12", data: pfx, password: "password", wantChain: []string{"azauth"}},
+		{name: "PKCS#12 with the wrong password", data: pfx, password: "wrong", wantErr: "password"},
+		{name: "not a certificate", data: []byte("garbage"), wantErr: "pkcs12"},
+		{name: "no private key", data: leafPEM, wantErr: "no private key"},
+		{name: "no matching certificate", data: join(caPEM, leafKeyPEM), wantErr: "no certificate matching"},
+		{name: "EC key", data: join(leafPEM, pkcs8PEM(t, ecKey)), wantErr: "only RSA keys"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			chain, key, err := parseCertificate(tc.data, tc.password)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("parseCertificate() error = %v, want %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, cert := range chain {
+				names = append(names, cert.Subject.CommonName)
+			}
+			if strings.Join(names, ",") != strings.Join(tc.wantChain, ",") {
+				t.Errorf("chain = %v, want %v", names, tc.wantChain)
+			}
+			if pub := chain[0].PublicKey.(*rsa.PublicKey); pub.N.Cmp(key.N) != 0 {
+				t.Error("the first certificate doesn't match the private key")
+			}
+		})
+	}
+}
+
+func TestWithClientCertificateFailsNew(t *testing.T) {
+	if _, err := New(WithClientCertificate([]byte("garbage"), "")); err == nil || !strings.Contains(err.Error(), "client certificate") {
+		t.Fatalf("New() error = %v, want a client certificate error", err)
+	}
+}
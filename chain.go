@@ -0,0 +1,181 @@
+package azauth
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/Azure/go-autorest/autorest/adal"
+	"github.com/Azure/go-autorest/autorest/azure/auth"
+	"github.com/Azure/go-autorest/logger"
+)
+
+// CredentialSource names a credential source of the default credential chain.
+type CredentialSource string
+
+const (
+	// SourceEnvironment authenticates with a client secret, client certificate, or username and password
+	// from the AZURE_* environment variables.
+	SourceEnvironment CredentialSource = "env"
+	// SourceWorkloadIdentity authenticates with AKS workload identity when AZURE_FEDERATED_TOKEN_FILE is set.
+	SourceWorkloadIdentity CredentialSource = "workloadidentity"
+	// SourceManagedIdentity authenticates with the managed identity of the host.
+	SourceManagedIdentity CredentialSource = "managedidentity"
+	// SourceAzureCLI authenticates with the Azure CLI login of the developer.
+	SourceAzureCLI CredentialSource = "cli"
+)
+
+const (
+	// excludeEnv lists credential sources to exclude from the chain, separated by commas, e.g. cli,managedidentity.
+	excludeEnv = "AZAUTH_EXCLUDE"
+)
+
+// chainCredentials maps each source to its credential.
+var chainCredentials = map[CredentialSource]credential{
+	SourceEnvironment:      environmentCredential,
+	SourceWorkloadIdentity: workloadIdentityCredential,
+	SourceManagedIdentity:  chainManagedIdentityCredential,
+	SourceAzureCLI:         azureCLICredential,
+}
+
+// DefaultCredentialChain returns the sources tried when no credential source is selected, in order.
+func DefaultCredentialChain() []CredentialSource {
+	return []CredentialSource{SourceEnvironment, SourceWorkloadIdentity, SourceManagedIdentity, SourceAzureCLI}
+}
+
+// WithCredentialChain selects the credential chain as the credential source and sets the sources it tries, in order.
+// The first source to produce a token is used for every later resource.
+// Without this option, clients without a credential source use DefaultCredentialChain.
+func WithCredentialChain(sources ...CredentialSource) Option {
+	return func(c *Config) {
+		c.chainSources = sources
+		c.credential = chainCredential
+	}
+}
+
+// WithoutCredentialSources excludes sources from the credential chain.
+// Sources listed in AZAUTH_EXCLUDE are excluded as well, so operators can disable them without code changes.
+func WithoutCredentialSources(sources ...CredentialSource) Option {
+	return func(c *Config) {
+		c.chainExclude = append(c.chainExclude, sources...)
+	}
+}
+
+// credentialChain returns the sources the chain tries, in order.
+func (c *Config) credentialChain() ([]CredentialSource, error) {
+	sources := c.chainSources
+	if sources == nil {
+		sources = DefaultCredentialChain()
+	}
+	exclude := append([]CredentialSource(nil), c.chainExclude...)
+	for _, name := range strings.Split(os.Getenv(excludeEnv), ",") {
+		if name = strings.ToLower(strings.TrimSpace(name)); name == "" {
+			continue
+		}
+		// reject typos rather than silently leaving a source enabled.
+		if _, ok := chainCredentials[CredentialSource(name)]; !ok {
+			return nil, fmt.Errorf("%s contains unknown credential source %q", excludeEnv, name)
+		}
+		exclude = append(exclude, CredentialSource(name))
+	}
+
+	var chain []CredentialSource
+	for _, source := range sources {
+		excluded := false
+		for _, e := range exclude {
+			if source == e {
+				excluded = true
+			}
+		}
+		if !excluded {
+			chain = append(chain, source)
+		}
+	}
+	return chain, nil
+}
+
+// chainCredential tries each source of the chain until one produces a token, and remembers it. Sources
+// are probed without chainMu, so a slow probe doesn't block resources once a source is selected.
+func chainCredential(c *Config, resource string) (*adal.ServicePrincipalToken, error) {
+	c.chainMu.Lock()
+	selected := c.chainSelected
+	c.chainMu.Unlock()
+	if selected != nil {
+		return selected(c, resource)
+	}
+
+	chain, err := c.credentialChain()
+	if err != nil {
+		return nil, err
+	}
+	var failures []string
+	for _, source := range chain {
+		cred, ok := chainCredentials[source]
+		if !ok {
+			return nil, fmt.Errorf("unknown credential source %q", source)
+		}
+		if source != SourceManagedIdentity {
+			// managed identity bounds its own requests with the IMDS timeout.
+			cred = c.bounded(cred, c.acquireTimeout)
+		}
+		spt, err := cred(c, resource)
+		if err == nil {
+			err = spt.EnsureFresh()
+		}
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", source, err))
+			continue
+		}
+		c.chainMu.Lock()
+		if c.chainSelected == nil {
+			logger.Instance.Writef(logger.LogInfo, "azauth: authenticating with credential source %s\n", source)
+			c.chainSelected = cred
+		}
+		c.chainMu.Unlock()
+		return spt, nil
+	}
+	if len(failures) == 0 {
+		return nil, errors.New("the credential chain has no sources")
+	}
+	return nil, fmt.Errorf("no credential source could authenticate: %s", strings.Join(failures, "; "))
+}
+
+// environmentCredential authenticates with the credentials in the AZURE_* environment variables, through
+// the same paths as WithClientSecret, WithClientCertificate, and WithUsernamePassword, so MSAL, CAE, x5c,
+// and the Config's client apply to them too.
+func environmentCredential(c *Config, resource string) (*adal.ServicePrincipalToken, error) {
+	if c.clientID() == "" || c.tenantID() == "" {
+		return nil, fmt.Errorf("%s and %s must be set", auth.ClientID, auth.TenantID)
+	}
+	if secret := c.clientSecret(); secret != "" {
+		return c.secretToken(secret, resource)
+	}
+	if path := c.setting(auth.CertificatePath); path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		chain, key, err := parseCertificate(data, c.setting(auth.CertificatePassword))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the client certificate %s: %w", path, err)
+		}
+		return c.certificateToken(chain, key, resource)
+	}
+	if username, password := c.setting(auth.Username), c.setting(auth.Password); username != "" && password != "" {
+		return c.passwordToken(username, password, resource)
+	}
+	return nil, fmt.Errorf("%s, %s, or %s and %s must be set", auth.ClientSecret, auth.CertificatePath, auth.Username, auth.Password)
+}
+
+// chainManagedIdentityCredential uses managed identity when a platform identity endpoint is configured
+// or IMDS answers, so hosts outside Azure don't wait on IMDS retries.
+func chainManagedIdentityCredential(c *Config, resource string) (*adal.ServicePrincipalToken, error) {
+	if !c.identityPlatform().configured {
+		if err := c.probeIMDS(); err != nil {
+			return nil, fmt.Errorf("no managed identity endpoint is available: %v", err)
+		}
+	}
+	return managedIdentityCredential(c, resource)
+}
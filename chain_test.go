@@ -0,0 +1,261 @@
+package azauth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/azure"
+	"github.com/Azure/go-autorest/autorest/azure/auth"
+)
+
+// aadServer is a TLS token service answering MSAL's tenant discovery and both token endpoints, recording
+// the path and form of the last token request and counting token requests. Once hung, token requests are answered
+// only when the client gives up.
+type aadServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	path     string
+	form     url.Values
+	requests int
+	hung     bool
+	// lifetime is how long issued tokens are valid, an hour when zero.
+	lifetime time.Duration
+}
+
+func newAADServer(t *testing.T) *aadServer {
+	t.Helper()
+	s := &aadServer{}
+	s.Server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/.well-known/openid-configuration"):
+			tenant := strings.Split(strings.TrimPrefix(r.URL.Path, "/"), "/")[0]
+			fmt.Fprintf(w, `{"token_endpoint":"%[1]s/%[2]s/oauth2/v2.0/token","authorization_endpoint":"%[1]s/%[2]s/oauth2/v2.0/authorize","issuer":"%[1]s/%[2]s/v2.0"}`, s.URL, tenant)
+		case strings.HasSuffix(r.URL.Path, "/oauth2/v2.0/token"):
+			if s.record(r) {
+				<-r.Context().Done()
+				return
+			}
+			fmt.Fprintf(w, `{"access_token":"token","token_type":"Bearer","expires_in":%d}`, s.tokenLifetime()/time.Second)
+		case strings.HasSuffix(r.URL.Path, "/oauth2/token"):
+			if s.record(r) {
+				<-r.Context().Done()
+				return
+			}
+			fmt.Fprintf(w, `{"access_token":"token","token_type":"Bearer","expires_in":"%d"}`, s.tokenLifetime()/time.Second)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// record records the token request r, reporting whether the server is hung.
+func (s *aadServer) record(r *http.Request) bool {
+	r.ParseForm()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.path = r.URL.Path
+	s.form = r.PostForm
+	s.requests++
+	return s.hung
+}
+
+func (s *aadServer) tokenLifetime() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.lifetime == 0 {
+		return time.Hour
+	}
+	return s.lifetime
+}
+
+func (s *aadServer) issueFor(lifetime time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lifetime = lifetime
+}
+
+func (s *aadServer) hang() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hung = true
+}
+
+func (s *aadServer) lastPath() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.path
+}
+
+func (s *aadServer) lastForm() url.Values {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.form
+}
+
+func (s *aadServer) tokenRequests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests
+}
+
+// options returns options authenticating against the server.
+func (s *aadServer) options() []Option {
+	env := azure.PublicCloud
+	env.ActiveDirectoryEndpoint = s.URL + "/"
+	return []Option{WithAzureEnvironment(env), WithRootCAs(trust(s.Server)), WithoutInstanceDiscovery()}
+}
+
+// selfSignedCertificate returns a PEM encoded self-signed certificate and its RSA private key.
+func selfSignedCertificate(t *testing.T) (certPEM, keyPEM []byte) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "azauth"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	return certPEM, keyPEM
+}
+
+// setCredentialEnvironment sets the AZURE_* credential variables to env, clearing the others.
+func setCredentialEnvironment(t *testing.T, env map[string]string) {
+	t.Helper()
+	for _, name := range []string{
+		auth.ClientID, auth.TenantID, auth.ClientSecret, auth.CertificatePath, auth.CertificatePassword,
+		auth.Username, auth.Password, clientCertificatePathEnv, clientCertificatePasswordEnv,
+	} {
+		t.Setenv(name, env[name])
+	}
+}
+
+func TestEnvironmentCredential(t *testing.T) {
+	certPEM, keyPEM := selfSignedCertificate(t)
+	certPath := filepath.Join(t.TempDir(), "cert.pem")
+	if err := os.WriteFile(certPath, append(certPEM, keyPEM...), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name      string
+		env       map[string]string
+		wantGrant string
+		wantField string
+		wantErr   string
+	}{
+		{
+			name:      "client secret",
+			env:       map[string]string{auth.ClientSecret: "secret"},
+			wantGrant: "client_credentials",
+			wantField: "client_secret",
+		},
+		{
+			name:      "client certificate",
+			env:       map[string]string{auth.CertificatePath: certPath},
+			wantGrant: "client_credentials",
+			wantField: "client_assertion",
+		},
+		{
+			name:      "username and password",
+			env:       map[string]string{auth.Username: "user", auth.Password: "password"},
+			wantGrant: "password",
+			wantField: "password",
+		},
+		{
+			name:    "unreadable certificate",
+			env:     map[string]string{auth.CertificatePath: filepath.Join(t.TempDir(), "missing.pem")},
+			wantErr: "missing.pem",
+		},
+		{
+			name:    "no credentials",
+			env:     map[string]string{},
+			wantErr: "must be set",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.env[auth.ClientID] = "client"
+			tc.env[auth.TenantID] = "tenant"
+			setCredentialEnvironment(t, tc.env)
+			srv := newAADServer(t)
+			c := newTestConfig(t, srv.options()...)
+
+			spt, err := c.tokenFrom(environmentCredential, c.armResource())
+			if err == nil {
+				err = spt.EnsureFresh()
+			}
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("tokenFrom(environmentCredential) error = %v, want %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			form := srv.lastForm()
+			if got := form.Get("grant_type"); got != tc.wantGrant {
+				t.Errorf("grant_type = %q, want %q", got, tc.wantGrant)
+			}
+			if form.Get(tc.wantField) == "" {
+				t.Errorf("the token request has no %s: %v", tc.wantField, form)
+			}
+		})
+	}
+}
+
+func TestChainCredentialConcurrentResources(t *testing.T) {
+	setCredentialEnvironment(t, map[string]string{auth.ClientID: "client", auth.TenantID: "tenant", auth.ClientSecret: "secret"})
+	srv := newAADServer(t)
+	c := newTestConfig(t, srv.options()...)
+
+	var wg sync.WaitGroup
+	errs := make([]error, 8)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			spt, err := chainCredential(c, fmt.Sprintf("https://resource%d.example.com", i))
+			if err == nil {
+				err = spt.EnsureFresh()
+			}
+			errs[i] = err
+		}(i)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("resource %d: %v", i, err)
+		}
+	}
+	c.chainMu.Lock()
+	defer c.chainMu.Unlock()
+	if c.chainSelected == nil {
+		t.Error("the chain selected no source")
+	}
+}
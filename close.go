@@ -0,0 +1,145 @@
+package azauth
+
+import (
+	"context"
+	"crypto/rsa"
+	"errors"
+	"net/url"
+
+	"github.com/Azure/go-autorest/autorest/adal"
+	"github.com/Azure/go-autorest/autorest/azure/auth"
+)
+
+// errClosed is returned when tokens are requested from a closed Config.
+var errClosed = errors.New("the Config is closed")
+
+// WithContext closes the Config once ctx is done, tying its background work to the lifetime of a server
+// or command.
+func WithContext(ctx context.Context) Option {
+	return func(c *Config) {
+		c.ctx = ctx
+	}
+}
+
+// watchContext closes the Config when the context set with WithContext is done.
+func (c *Config) watchContext() {
+	if c.ctx == nil {
+		return
+	}
+	c.workers.Add(1)
+	go func() {
+		select {
+		case <-c.ctx.Done():
+			// Close waits for the workers, so this one is done before it closes.
+			c.workers.Done()
+			c.Close()
+		case <-c.stop:
+			c.workers.Done()
+		}
+	}()
+}
+
+// Close stops the background refresher and stale token retries, cancels token acquisitions and refreshes
+// in progress and waits for them to end, and drops the Config's cached tokens and secrets so they can be
+// collected. Persisted tokens are written as they are acquired, so they survive Close. Go can't guarantee
+// strings are wiped from memory, but private keys are zeroed. Tokens can't be acquired or refreshed through a
+// closed Config, though clients authorized earlier keep the token they hold until it expires. Close returns
+// the errors of closing the Configs of other tenants, and is safe to call more than once.
+func (c *Config) Close() error {
+	var err error
+	c.stopOnce.Do(func() {
+		c.closeMu.Lock()
+		close(c.stop)
+		c.closeMu.Unlock()
+		c.workers.Wait()
+		// no acquisitions start once stop is closed, so none use the secrets after this.
+		c.acquisitions.Wait()
+		err = c.closeTenants()
+
+		c.cacheMu.Lock()
+		c.tokens = nil
+		c.cacheMu.Unlock()
+
+		c.oboMu.Lock()
+		c.oboTokens = nil
+		c.oboMu.Unlock()
+
+		c.settingsMu.Lock()
+		for _, name := range []string{auth.ClientSecret, auth.CertificatePassword, auth.Password} {
+			delete(c.settings.Values, name)
+		}
+		c.key = ""
+		if c.privateKey != nil {
+			zeroKey(c.privateKey)
+			c.privateKey = nil
+		}
+		c.settingsMu.Unlock()
+	})
+	return err
+}
+
+// tracked returns cred with its acquisitions and refreshes counted, so Close waits for those in progress
+// before zeroing the secrets they use, and fails those started after it. Refreshes are canceled by Close.
+func (c *Config) tracked(cred credential) credential {
+	return func(c *Config, resource string) (*adal.ServicePrincipalToken, error) {
+		if err := c.beginAcquisition(); err != nil {
+			return nil, err
+		}
+		inner, err := cred(c, resource)
+		c.acquisitions.Done()
+		if err != nil {
+			return nil, err
+		}
+		c.useClient(inner)
+		return newCustomToken(url.URL{Scheme: "tracked"}, resource, func(ctx context.Context, resource string) (*adal.Token, error) {
+			if err := c.beginAcquisition(); err != nil {
+				return nil, err
+			}
+			defer c.acquisitions.Done()
+			ctx, cancel := context.WithCancel(ctx)
+			defer cancel()
+			go func() {
+				select {
+				case <-c.stop:
+					cancel()
+				case <-ctx.Done():
+				}
+			}()
+			if err := inner.RefreshWithContext(ctx); err != nil {
+				return nil, err
+			}
+			token := inner.Token()
+			return &token, nil
+		})
+	}
+}
+
+// beginAcquisition counts an acquisition in progress, or returns errClosed once Close was called.
+func (c *Config) beginAcquisition() error {
+	c.closeMu.Lock()
+	defer c.closeMu.Unlock()
+	if c.closed() {
+		return errClosed
+	}
+	c.acquisitions.Add(1)
+	return nil
+}
+
+// closed reports whether Close was called.
+func (c *Config) closed() bool {
+	select {
+	case <-c.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// zeroKey overwrites the private values of key.
+func zeroKey(key *rsa.PrivateKey) {
+	key.D.SetInt64(0)
+	for _, prime := range key.Primes {
+		prime.SetInt64(0)
+	}
+	key.Precomputed = rsa.PrecomputedValues{}
+}
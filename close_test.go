@@ -0,0 +1,161 @@
+package azauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/adal"
+)
+
+// blockingCredential returns a credential whose refreshes wait for their context to be done, signaling
+// started when one begins.
+func blockingCredential(started chan<- struct{}) credential {
+	return func(c *Config, resource string) (*adal.ServicePrincipalToken, error) {
+		return newCustomToken(url.URL{Scheme: "test"}, resource, func(ctx context.Context, resource string) (*adal.Token, error) {
+			started <- struct{}{}
+			<-ctx.Done()
+			return nil, ctx.Err()
+		})
+	}
+}
+
+func TestCloseCancelsRefreshes(t *testing.T) {
+	c := newTestConfig(t)
+	started := make(chan struct{}, 1)
+	c.credential = blockingCredential(started)
+	spt, err := c.cachedToken("https://resource.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	refreshed := make(chan error, 1)
+	go func() { refreshed <- spt.EnsureFresh() }()
+	<-started
+	closed := make(chan error, 1)
+	go func() { closed <- c.Close() }()
+
+	select {
+	case err := <-refreshed:
+		if err == nil {
+			t.Error("the refresh in progress succeeded after Close")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close didn't cancel the refresh in progress")
+	}
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Errorf("Close() = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close didn't return after the refresh ended")
+	}
+}
+
+func TestClosedConfig(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		acquire func(c *Config, spt *adal.ServicePrincipalToken) error
+	}{
+		{
+			name: "refresh of a token acquired earlier",
+			acquire: func(c *Config, spt *adal.ServicePrincipalToken) error {
+				return spt.Refresh()
+			},
+		},
+		{
+			name: "new resource",
+			acquire: func(c *Config, spt *adal.ServicePrincipalToken) error {
+				_, err := c.cachedToken("https://other.example.com")
+				return err
+			},
+		},
+		{
+			name: "on-behalf-of token",
+			acquire: func(c *Config, spt *adal.ServicePrincipalToken) error {
+				_, err := c.GetAuthorizerOnBehalfOf("assertion", "https://resource.example.com")
+				return err
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := newTestConfig(t, WithAccessToken("token", time.Now().Add(time.Hour), nil))
+			spt, err := c.cachedToken("https://resource.example.com")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := c.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if err := tc.acquire(c, spt); err == nil {
+				t.Error("acquired a token through a closed Config")
+			}
+		})
+	}
+}
+
+func TestCloseZeroesKeyAfterAcquisitions(t *testing.T) {
+	certPEM, keyPEM := selfSignedCertificate(t)
+	c := newTestConfig(t, WithClientCertificate(append(certPEM, keyPEM...), ""))
+	_, key := c.clientCertificate()
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	c.credential = func(c *Config, resource string) (*adal.ServicePrincipalToken, error) {
+		started <- struct{}{}
+		<-release
+		// an acquisition in progress still sees the key it started with.
+		if key.D.Sign() == 0 {
+			return nil, errors.New("the private key was zeroed during an acquisition")
+		}
+		return adal.NewServicePrincipalTokenFromManualToken(adal.OAuthConfig{TokenEndpoint: url.URL{Scheme: "test"}}, "client", resource, adal.Token{
+			AccessToken: "token",
+			ExpiresOn:   json.Number(strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)),
+			Type:        "Bearer",
+		})
+	}
+
+	acquired := make(chan error, 1)
+	go func() {
+		_, err := c.cachedToken("https://resource.example.com")
+		acquired <- err
+	}()
+	<-started
+	closed := make(chan struct{})
+	go func() {
+		c.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+		t.Fatal("Close returned while an acquisition was in progress")
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(release)
+	if err := <-acquired; err != nil {
+		t.Error(err)
+	}
+	<-closed
+	if key.D.Sign() != 0 {
+		t.Error("Close didn't zero the private key")
+	}
+}
+
+func TestWithContextClosesConfig(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	c := newTestConfig(t, WithContext(ctx))
+	cancel()
+	select {
+	case <-c.stop:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the Config wasn't closed when its context was canceled")
+	}
+	// Close waits for the context watcher, so it returns only once the watcher is gone.
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
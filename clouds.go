@@ -0,0 +1,110 @@
+package azauth
+
+import (
+	"github.com/Azure/go-autorest/autorest/azure"
+)
+
+// CloudEndpoints lists the endpoints, token audiences, and DNS suffixes of a cloud's services. Services a
+// cloud doesn't offer are empty.
+type CloudEndpoints struct {
+	Name string
+
+	ActiveDirectory         string
+	ResourceManager         string
+	ResourceManagerAudience string
+
+	MicrosoftGraph         string
+	MicrosoftGraphAudience string
+	AADGraph               string
+	AADGraphAudience       string
+
+	KeyVaultAudience   string
+	KeyVaultSuffix     string
+	ManagedHSMAudience string
+	ManagedHSMSuffix   string
+
+	StorageAudience  string
+	StorageSuffix    string
+	DataLakeAudience string
+	DataLakeSuffix   string
+
+	SQLAudience      string
+	SQLSuffix        string
+	OSSRDBMSAudience string
+	MySQLSuffix      string
+	PostgreSQLSuffix string
+	MariaDBSuffix    string
+	CosmosDBAudience string
+	CosmosDBSuffix   string
+	SynapseAudience  string
+	SynapseSuffix    string
+
+	ServiceBusAudience   string
+	ServiceBusSuffix     string
+	BatchAudience        string
+	LogAnalyticsAudience string
+	AppInsightsAudience  string
+
+	ContainerRegistrySuffix string
+}
+
+// Clouds returns the endpoints of the public cloud, Azure US Government, and Azure China (21Vianet).
+func Clouds() []CloudEndpoints {
+	return []CloudEndpoints{
+		CloudEndpointsFor(azure.PublicCloud),
+		CloudEndpointsFor(azure.USGovernmentCloud),
+		CloudEndpointsFor(azure.ChinaCloud),
+	}
+}
+
+// Endpoints returns the endpoints of the Config's cloud.
+func (c *Config) Endpoints() CloudEndpoints {
+	return CloudEndpointsFor(*c.env)
+}
+
+// CloudEndpointsFor returns the endpoints of the cloud env describes.
+func CloudEndpointsFor(env azure.Environment) CloudEndpoints {
+	ids := env.ResourceIdentifiers
+	return CloudEndpoints{
+		Name:                    env.Name,
+		ActiveDirectory:         available(env.ActiveDirectoryEndpoint),
+		ResourceManager:         available(env.ResourceManagerEndpoint),
+		ResourceManagerAudience: available(armAudience(env)),
+		MicrosoftGraph:          available(env.MicrosoftGraphEndpoint),
+		MicrosoftGraphAudience:  available(ids.MicrosoftGraph),
+		AADGraph:                available(env.GraphEndpoint),
+		AADGraphAudience:        available(ids.Graph),
+		KeyVaultAudience:        available(keyVaultAudience(env)),
+		KeyVaultSuffix:          available(env.KeyVaultDNSSuffix),
+		ManagedHSMAudience:      available(managedHSMAudience(env)),
+		ManagedHSMSuffix:        available(managedHSMSuffix(env)),
+		StorageAudience:         available(ids.Storage),
+		StorageSuffix:           available(env.StorageEndpointSuffix),
+		DataLakeAudience:        available(ids.Datalake),
+		DataLakeSuffix:          available(env.DatalakeSuffix),
+		SQLAudience:             available(ids.SQLDatabase),
+		SQLSuffix:               available(env.SQLDatabaseDNSSuffix),
+		OSSRDBMSAudience:        available(ids.OSSRDBMS),
+		MySQLSuffix:             available(env.MySQLDatabaseDNSSuffix),
+		PostgreSQLSuffix:        available(env.PostgresqlDatabaseDNSSuffix),
+		MariaDBSuffix:           available(env.MariaDBDNSSuffix),
+		CosmosDBAudience:        available(ids.CosmosDB),
+		CosmosDBSuffix:          available(env.CosmosDBDNSSuffix),
+		SynapseAudience:         available(ids.Synapse),
+		SynapseSuffix:           available(env.SynapseEndpointSuffix),
+		ServiceBusAudience:      available(ids.ServiceBus),
+		ServiceBusSuffix:        available(env.ServiceBusEndpointSuffix),
+		BatchAudience:           available(ids.Batch),
+		LogAnalyticsAudience:    available(logAnalyticsAudience(env)),
+		AppInsightsAudience:     available(applicationInsightsAudience(env)),
+		ContainerRegistrySuffix: available(env.ContainerRegistryDNSSuffix),
+	}
+}
+
+// available returns value, or an empty string when azure marks it as not available.
+func available(value string) string {
+	if value == azure.NotAvailable {
+		return ""
+	}
+	return value
+}
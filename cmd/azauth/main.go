@@ -0,0 +1,43 @@
+// Command azauth prints credentials acquired with azauth's default credential chain.
+//
+// As a kubeconfig exec credential plugin for AAD-enabled AKS clusters:
+//
+//	users:
+//	- name: aks
+//	  user:
+//	    exec:
+//	      apiVersion: client.authentication.k8s.io/v1
+//	      command: azauth
+//	      args: ["exec-credential"]
+//	      interactiveMode: Never
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/alexeldeib/azauth"
+)
+
+func main() {
+	if len(os.Args) < 2 || os.Args[1] != "exec-credential" {
+		fmt.Fprintln(os.Stderr, "usage: azauth exec-credential [-server-id id]")
+		os.Exit(2)
+	}
+	flags := flag.NewFlagSet("exec-credential", flag.ExitOnError)
+	serverID := flags.String("server-id", azauth.AKSServerAppID, "application ID of the AKS AAD server")
+	flags.Parse(os.Args[2:])
+
+	config, err := azauth.New(azauth.UserAgent("azauth-exec-credential"))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	credential, err := config.ExecCredential(*serverID)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Println(string(credential))
+}
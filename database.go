@@ -0,0 +1,120 @@
+package azauth
+
+import (
+	"context"
+	"database/sql/driver"
+)
+
+// SQLAccessToken returns an access token for Azure SQL Database in the Config's cloud, e.g. for
+// https://database.windows.net/ in the public cloud. Its signature matches the token provider of go-mssqldb's
+// security token connector, which calls it for every new connection, including reconnects after a connection
+// reset, so connections always authenticate with a fresh token:
+//
+//	connector, err := mssql.NewSecurityTokenConnector(dbConfig, config.SQLAccessToken)
+//	db := sql.OpenDB(connector)
+func (c *Config) SQLAccessToken(ctx context.Context) (string, error) {
+	resource, err := c.serviceResource("Azure SQL Database", c.env.ResourceIdentifiers.SQLDatabase)
+	if err != nil {
+		return "", err
+	}
+	return c.accessToken(ctx, resource)
+}
+
+// accessToken returns a fresh access token for resource from the Config's cached tokens.
+func (c *Config) accessToken(ctx context.Context, resource string) (string, error) {
+	spt, err := c.cachedToken(resource)
+	if err != nil {
+		return "", err
+	}
+	if err := spt.EnsureFreshWithContext(ctx); err != nil {
+		return "", err
+	}
+	return spt.OAuthToken(), nil
+}
+
+// PostgresAccessToken returns an access token for Azure Database for PostgreSQL in the Config's cloud, e.g.
+// for https://ossrdbms-aad.database.windows.net in the public cloud, to be sent as the connection's password.
+// With pgx, set it on every connection a pool opens:
+//
+//	poolConfig.BeforeConnect = func(ctx context.Context, cc *pgx.ConnConfig) (err error) {
+//		cc.Password, err = config.PostgresAccessToken(ctx)
+//		return err
+//	}
+func (c *Config) PostgresAccessToken(ctx context.Context) (string, error) {
+	resource, err := c.serviceResource("Azure Database for PostgreSQL", c.env.ResourceIdentifiers.OSSRDBMS)
+	if err != nil {
+		return "", err
+	}
+	return c.accessToken(ctx, resource)
+}
+
+// PostgresConnector returns a connector opening connections with drv, e.g. &pq.Driver{} or pgx's
+// stdlib.GetDefaultDriver(), to the data source name dsn returns for a password. Every connection is
+// opened with a fresh PostgresAccessToken as its password, so pooled connections opened after the first
+// token expires still authenticate:
+//
+//	db := sql.OpenDB(config.PostgresConnector(&pq.Driver{}, func(password string) string {
+//		return "host=myserver.postgres.database.azure.com user=myidentity dbname=app sslmode=require password='" + password + "'"
+//	}))
+func (c *Config) PostgresConnector(drv driver.Driver, dsn func(password string) string) driver.Connector {
+	return &tokenConnector{drv: drv, dsn: dsn, token: c.PostgresAccessToken}
+}
+
+// MySQLAccessToken returns an access token for Azure Database for MySQL in the Config's cloud, e.g. for
+// https://ossrdbms-aad.database.windows.net in the public cloud. MySQL receives it through the cleartext
+// password plugin, so connections must allow cleartext passwords and use TLS. With go-sql-driver/mysql,
+// set it on every connection:
+//
+//	cfg.AllowCleartextPasswords = true
+//	cfg.TLSConfig = "true"
+//	cfg.Apply(mysql.BeforeConnect(func(ctx context.Context, cfg *mysql.Config) (err error) {
+//		cfg.Passwd, err = config.MySQLAccessToken(ctx)
+//		return err
+//	}))
+func (c *Config) MySQLAccessToken(ctx context.Context) (string, error) {
+	resource, err := c.serviceResource("Azure Database for MySQL", c.env.ResourceIdentifiers.OSSRDBMS)
+	if err != nil {
+		return "", err
+	}
+	return c.accessToken(ctx, resource)
+}
+
+// MySQLConnector returns a connector opening connections with drv, e.g. &mysql.MySQLDriver{}, to the data
+// source name dsn returns for a password, with a fresh MySQLAccessToken as the password of each. The data
+// source name must set allowCleartextPasswords=true and tls=true:
+//
+//	db := sql.OpenDB(config.MySQLConnector(&mysql.MySQLDriver{}, func(password string) string {
+//		return "myidentity:" + password + "@tcp(myserver.mysql.database.azure.com)/app?allowCleartextPasswords=true&tls=true"
+//	}))
+func (c *Config) MySQLConnector(drv driver.Driver, dsn func(password string) string) driver.Connector {
+	return &tokenConnector{drv: drv, dsn: dsn, token: c.MySQLAccessToken}
+}
+
+// tokenConnector opens connections with a fresh token as the password of each.
+type tokenConnector struct {
+	drv   driver.Driver
+	dsn   func(password string) string
+	token func(ctx context.Context) (string, error)
+}
+
+// Connect implements driver.Connector.
+func (t *tokenConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	token, err := t.token(ctx)
+	if err != nil {
+		return nil, err
+	}
+	dsn := t.dsn(token)
+	if d, ok := t.drv.(driver.DriverContext); ok {
+		connector, err := d.OpenConnector(dsn)
+		if err != nil {
+			return nil, err
+		}
+		return connector.Connect(ctx)
+	}
+	return t.drv.Open(dsn)
+}
+
+// Driver implements driver.Connector.
+func (t *tokenConnector) Driver() driver.Driver {
+	return t.drv
+}
@@ -0,0 +1,49 @@
+package azauth
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/azure"
+)
+
+// DataLakeGen1Resource returns the audience of Data Lake Storage Gen1 tokens in the Config's cloud, e.g.
+// https://datalake.azure.net/ in the public cloud. Gen1 is a separate service from Gen2, which is
+// authorized with the storage audience of DataLakeGen2Resource.
+func (c *Config) DataLakeGen1Resource() (string, error) {
+	return c.serviceResource("Data Lake Storage Gen1", c.env.ResourceIdentifiers.Datalake)
+}
+
+// DataLakeGen1Endpoint returns the endpoint of the Data Lake Storage Gen1 account in the Config's cloud, e.g.
+// https://myaccount.azuredatalakestore.net in the public cloud.
+func (c *Config) DataLakeGen1Endpoint(account string) (string, error) {
+	suffix := c.env.DatalakeSuffix
+	if suffix == "" || suffix == azure.NotAvailable {
+		return "", fmt.Errorf("Data Lake Storage Gen1 is not available in %s", c.env.Name)
+	}
+	return "https://" + account + "." + strings.TrimPrefix(suffix, "."), nil
+}
+
+// AuthorizeDataLakeGen1Client authorizes client, the autorest.Client of a Data Lake Storage Gen1 filesystem
+// client, for DataLakeGen1Resource.
+func (c *Config) AuthorizeDataLakeGen1Client(client *autorest.Client) error {
+	resource, err := c.DataLakeGen1Resource()
+	if err != nil {
+		return err
+	}
+	return c.AuthorizeClientForResource(client, resource)
+}
+
+// DataLakeGen2Resource returns the audience of Data Lake Storage Gen2 tokens in the Config's cloud. Gen2 is
+// built on Blob storage, so it is the storage audience, e.g. https://storage.azure.com/ in the public cloud,
+// and accounts are reached at StorageEndpoint with StorageDFS.
+func (c *Config) DataLakeGen2Resource() (string, error) {
+	return c.storageResource()
+}
+
+// AuthorizeDataLakeGen2Client authorizes client, the autorest.Client of a Data Lake Storage Gen2 filesystem
+// client, for DataLakeGen2Resource.
+func (c *Config) AuthorizeDataLakeGen2Client(client *autorest.Client) error {
+	return c.AuthorizeStorageClient(client)
+}
@@ -0,0 +1,113 @@
+package azauth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/adal"
+)
+
+// developerToolTimeout bounds how long developer tool credentials wait on the tool's process.
+const developerToolTimeout = 30 * time.Second
+
+// WithAzureDeveloperCLI selects the Azure Developer CLI as the credential source, reusing the developer's
+// `azd auth login` session. The tenant set with Tenant or AZURE_TENANT_ID is passed to azd when present.
+func WithAzureDeveloperCLI() Option {
+	return func(c *Config) {
+		c.credential = azdCredential
+	}
+}
+
+func azdCredential(c *Config, resource string) (*adal.ServicePrincipalToken, error) {
+	args := []string{"auth", "token", "--output", "json", "--scope", scopeForResource(resource)}
+	if tenant := c.tenantID(); tenant != "" {
+		args = append(args, "--tenant-id", tenant)
+	}
+	return newCustomToken(url.URL{Scheme: "azd"}, resource, func(ctx context.Context, _ string) (*adal.Token, error) {
+		ctx, cancel := context.WithTimeout(ctx, developerToolTimeout)
+		defer cancel()
+
+		var stdout, stderr bytes.Buffer
+		cmd := exec.CommandContext(ctx, "azd", args...)
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			return nil, fmt.Errorf("azd auth token failed: %v: %s", err, strings.TrimSpace(stderr.String()))
+		}
+
+		var out struct {
+			Token     string    `json:"token"`
+			ExpiresOn time.Time `json:"expiresOn"`
+		}
+		if err := json.Unmarshal(stdout.Bytes(), &out); err != nil {
+			return nil, fmt.Errorf("failed to parse azd auth token output: %v", err)
+		}
+		return &adal.Token{
+			AccessToken: out.Token,
+			ExpiresOn:   json.Number(strconv.FormatInt(out.ExpiresOn.Unix(), 10)),
+			Resource:    resource,
+			Type:        "Bearer",
+		}, nil
+	})
+}
+
+// WithAzureCLI selects the Azure CLI as the credential source, reusing the developer's `az login` session.
+// The tenant set with Tenant or AZURE_TENANT_ID is passed to az when present. Recent releases of the Azure
+// CLI sign in through the Web Account Manager (WAM) on Windows, so their tokens satisfy conditional access
+// policies requiring a compliant device, which WithInteractiveBrowser can't.
+func WithAzureCLI() Option {
+	return func(c *Config) {
+		c.credential = azureCLICredential
+	}
+}
+
+func azureCLICredential(c *Config, resource string) (*adal.ServicePrincipalToken, error) {
+	args := []string{"account", "get-access-token", "--output", "json", "--resource", resource}
+	if tenant := c.tenantID(); tenant != "" {
+		args = append(args, "--tenant", tenant)
+	}
+	return newCustomToken(url.URL{Scheme: "az"}, resource, func(ctx context.Context, _ string) (*adal.Token, error) {
+		ctx, cancel := context.WithTimeout(ctx, developerToolTimeout)
+		defer cancel()
+
+		var stdout, stderr bytes.Buffer
+		cmd := exec.CommandContext(ctx, "az", args...)
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			return nil, fmt.Errorf("az account get-access-token failed: %v: %s", err, strings.TrimSpace(stderr.String()))
+		}
+
+		var out struct {
+			AccessToken string      `json:"accessToken"`
+			ExpiresOn   string      `json:"expiresOn"`
+			ExpiresOnTS json.Number `json:"expires_on"`
+			TokenType   string      `json:"tokenType"`
+		}
+		if err := json.Unmarshal(stdout.Bytes(), &out); err != nil {
+			return nil, fmt.Errorf("failed to parse az account get-access-token output: %v", err)
+		}
+		// older CLI releases only report expiresOn, in local time.
+		expiresOn := out.ExpiresOnTS
+		if expiresOn == "" {
+			t, err := time.ParseInLocation("2006-01-02 15:04:05.999999", out.ExpiresOn, time.Local)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse az token expiry %q: %v", out.ExpiresOn, err)
+			}
+			expiresOn = json.Number(strconv.FormatInt(t.Unix(), 10))
+		}
+		return &adal.Token{
+			AccessToken: out.AccessToken,
+			ExpiresOn:   expiresOn,
+			Resource:    resource,
+			Type:        out.TokenType,
+		}, nil
+	})
+}
@@ -0,0 +1,232 @@
+package azauth
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Azure/go-autorest/autorest/azure"
+	"github.com/Azure/go-autorest/autorest/azure/auth"
+	"github.com/Azure/go-autorest/logger"
+)
+
+// authorityHostEnv overrides the login endpoint of the cloud, as in the Azure SDKs.
+const authorityHostEnv = "AZURE_AUTHORITY_HOST"
+
+// WithEnvironment selects the cloud by name, e.g. AzureUSGovernmentCloud or AzureChinaCloud, instead of
+// AZURE_ENVIRONMENT, so one binary can construct Configs for several clouds. Unknown names fail New.
+func WithEnvironment(name string) Option {
+	return func(c *Config) {
+		env, err := azure.EnvironmentFromName(name)
+		if err != nil {
+			c.optionErrs = append(c.optionErrs, err)
+			return
+		}
+		c.env = &env
+	}
+}
+
+// WithAzureEnvironment selects the cloud described by env, for clouds azure has no name for.
+func WithAzureEnvironment(env azure.Environment) Option {
+	return func(c *Config) {
+		c.env = &env
+	}
+}
+
+// WithCloudDetection selects the cloud the host runs in, read from IMDS, when neither AZURE_ENVIRONMENT,
+// AZURE_ENVIRONMENT_FILEPATH, nor an option selects one, instead of defaulting to the public cloud. Off Azure,
+// New waits on the IMDS probe before falling back to the public cloud.
+func WithCloudDetection() Option {
+	return func(c *Config) {
+		c.detectCloud = true
+	}
+}
+
+// detectEnvironment selects the cloud named by IMDS when detection is enabled and the environment sets none.
+func (c *Config) detectEnvironment() {
+	if !c.detectCloud || os.Getenv(auth.EnvironmentName) != "" || os.Getenv(azure.EnvironmentFilepathName) != "" {
+		return
+	}
+	if err := c.probeIMDS(); err != nil {
+		logger.Instance.Writef(logger.LogInfo, "azauth: IMDS is unavailable, using the public cloud: %v\n", err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), imdsProbeTimeout)
+	defer cancel()
+	name, err := c.imdsMetadata(ctx, "compute/azEnvironment")
+	if err != nil {
+		logger.Instance.Writef(logger.LogWarning, "azauth: failed to detect the cloud, using the public cloud: %v\n", err)
+		return
+	}
+	env, err := azure.EnvironmentFromName(name)
+	if err != nil {
+		logger.Instance.Writef(logger.LogWarning, "azauth: IMDS reported unknown cloud %q, using the public cloud\n", name)
+		return
+	}
+	c.env = &env
+}
+
+// WithEnvironmentFile selects the cloud described by the JSON environment file at path, as written for
+// Azure Stack Hub. New reads AZURE_ENVIRONMENT_FILEPATH the same way when AZURE_ENVIRONMENT is unset.
+func WithEnvironmentFile(path string) Option {
+	return func(c *Config) {
+		env, err := azure.EnvironmentFromFile(path)
+		if err != nil {
+			c.optionErrs = append(c.optionErrs, err)
+			return
+		}
+		c.env = &env
+	}
+}
+
+// environmentFile returns the environment in AZURE_ENVIRONMENT_FILEPATH when it is set without
+// AZURE_ENVIRONMENT, which auth only reads it for when set to AzureStackCloud.
+func environmentFile() (*azure.Environment, error) {
+	path := os.Getenv(azure.EnvironmentFilepathName)
+	if path == "" || os.Getenv(auth.EnvironmentName) != "" {
+		return nil, nil
+	}
+	env, err := azure.EnvironmentFromFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return &env, nil
+}
+
+// armResource returns the audience of Resource Manager tokens. It differs from the Resource Manager
+// endpoint on Azure Stack Hub, whose audiences are application ID URIs of the stamp's ADFS or AAD tenant.
+func (c *Config) armResource() string {
+	return armAudience(*c.env)
+}
+
+func armAudience(env azure.Environment) string {
+	if env.TokenAudience != "" {
+		return env.TokenAudience
+	}
+	return env.ResourceManagerEndpoint
+}
+
+// keyVaultResource returns the audience of Key Vault tokens, derived from the Key Vault DNS suffix for
+// environment files that omit it, as Azure Stack Hub's often do.
+func (c *Config) keyVaultResource() string {
+	return keyVaultAudience(*c.env)
+}
+
+// keyVaultAudience returns the Key Vault audience of env without a trailing slash: environments spell the
+// Key Vault endpoint with one, e.g. https://vault.azure.net/, but Key Vault rejects tokens issued for it.
+func keyVaultAudience(env azure.Environment) string {
+	if env.ResourceIdentifiers.KeyVault != "" && env.ResourceIdentifiers.KeyVault != azure.NotAvailable {
+		return strings.TrimSuffix(env.ResourceIdentifiers.KeyVault, "/")
+	}
+	return "https://" + strings.Trim(env.KeyVaultDNSSuffix, "./")
+}
+
+// serviceResource returns resource, the audience of service in the Config's cloud, failing when the cloud
+// doesn't offer it.
+func (c *Config) serviceResource(service, resource string) (string, error) {
+	if resource == "" || resource == azure.NotAvailable {
+		return "", fmt.Errorf("%s is not available in %s", service, c.env.Name)
+	}
+	return resource, nil
+}
+
+// WithResourceManagerEndpoint overrides the Resource Manager endpoint of the selected cloud, e.g. for a
+// private ARM endpoint. Like the other field overrides, it applies whichever option selects the cloud.
+func WithResourceManagerEndpoint(endpoint string) Option {
+	return overrideEnvironment(func(env *azure.Environment) {
+		env.ResourceManagerEndpoint = endpoint
+	})
+}
+
+// WithActiveDirectoryEndpoint overrides the AAD or ADFS authority of the selected cloud.
+func WithActiveDirectoryEndpoint(endpoint string) Option {
+	return overrideEnvironment(func(env *azure.Environment) {
+		env.ActiveDirectoryEndpoint = endpoint
+	})
+}
+
+// WithKeyVaultDNSSuffix overrides the Key Vault DNS suffix of the selected cloud, along with the Key Vault
+// endpoint and audience derived from it.
+func WithKeyVaultDNSSuffix(suffix string) Option {
+	return overrideEnvironment(func(env *azure.Environment) {
+		env.KeyVaultDNSSuffix = suffix
+		env.KeyVaultEndpoint = "https://" + suffix + "/"
+		env.ResourceIdentifiers.KeyVault = "https://" + suffix
+	})
+}
+
+// WithStorageEndpointSuffix overrides the storage endpoint suffix of the selected cloud.
+func WithStorageEndpointSuffix(suffix string) Option {
+	return overrideEnvironment(func(env *azure.Environment) {
+		env.StorageEndpointSuffix = suffix
+	})
+}
+
+// WithTokenAudience overrides the audience of Resource Manager tokens for the selected cloud.
+func WithTokenAudience(audience string) Option {
+	return overrideEnvironment(func(env *azure.Environment) {
+		env.TokenAudience = audience
+	})
+}
+
+// WithAuthorityHost overrides the login endpoint of the selected cloud, e.g. https://login.microsoftonline.us,
+// for disconnected and sovereign deployments with their own authority. AZURE_AUTHORITY_HOST sets it without
+// code changes; the option takes precedence.
+func WithAuthorityHost(host string) Option {
+	return overrideEnvironment(func(env *azure.Environment) {
+		env.ActiveDirectoryEndpoint = withSlash(host)
+	})
+}
+
+// overrideEnvironment returns an option patching the environment once every option has been applied.
+func overrideEnvironment(override func(env *azure.Environment)) Option {
+	return func(c *Config) {
+		c.envOverrides = append(c.envOverrides, override)
+	}
+}
+
+// applyEnvironmentOverrides patches a copy of the selected environment with the field overrides.
+// AZURE_AUTHORITY_HOST is applied first, so options win over it.
+func (c *Config) applyEnvironmentOverrides() {
+	overrides := c.envOverrides
+	if host := os.Getenv(authorityHostEnv); host != "" {
+		overrides = append([]func(*azure.Environment){func(env *azure.Environment) {
+			env.ActiveDirectoryEndpoint = withSlash(host)
+		}}, overrides...)
+	}
+	if len(overrides) == 0 {
+		return
+	}
+	env := *c.env
+	for _, override := range overrides {
+		override(&env)
+	}
+	c.env = &env
+}
+
+// Environment returns a copy of the Config's cloud environment.
+func (c *Config) Environment() azure.Environment {
+	return *c.env
+}
+
+// ResourceManagerEndpoint returns the Resource Manager endpoint of the Config's cloud, the base URI of
+// management clients.
+func (c *Config) ResourceManagerEndpoint() string {
+	return c.env.ResourceManagerEndpoint
+}
+
+// KeyVaultEndpoint returns the Key Vault endpoint of the Config's cloud, e.g. https://vault.azure.net/.
+// Vaults are addressed as subdomains of it.
+func (c *Config) KeyVaultEndpoint() string {
+	if c.env.KeyVaultEndpoint != "" {
+		return c.env.KeyVaultEndpoint
+	}
+	return "https://" + strings.TrimPrefix(c.env.KeyVaultDNSSuffix, ".") + "/"
+}
+
+// StorageSuffix returns the storage endpoint suffix of the Config's cloud, e.g. core.windows.net, so the blob
+// endpoint of an account is https://<account>.blob.<suffix>/.
+func (c *Config) StorageSuffix() string {
+	return c.env.StorageEndpointSuffix
+}
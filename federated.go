@@ -0,0 +1,207 @@
+package azauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/adal"
+	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/confidential"
+)
+
+const (
+	// federatedTokenFileEnv is set by the AKS workload identity webhook to the path of the projected service account token.
+	federatedTokenFileEnv = "AZURE_FEDERATED_TOKEN_FILE"
+
+	githubTokenURLEnv   = "ACTIONS_ID_TOKEN_REQUEST_URL"
+	githubTokenTokenEnv = "ACTIONS_ID_TOKEN_REQUEST_TOKEN"
+
+	// gitlabJobJWTEnv holds the predefined GitLab CI job token, superseded by id_tokens in newer GitLab releases.
+	gitlabJobJWTEnv = "CI_JOB_JWT_V2"
+
+	gceIdentityEndpoint = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/identity"
+	// gceTimeout bounds each identity token request to the GCE metadata server.
+	gceTimeout = 5 * time.Second
+
+	// federatedAudience is the audience AAD expects on federated client assertions.
+	federatedAudience = "api://AzureADTokenExchange"
+)
+
+// WithWorkloadIdentity selects AKS workload identity as the credential source.
+// The projected Kubernetes token named by AZURE_FEDERATED_TOKEN_FILE is exchanged for an AAD token.
+// The file is re-read on every refresh since kubelet rotates it.
+func WithWorkloadIdentity() Option {
+	return func(c *Config) {
+		c.credential = workloadIdentityCredential
+	}
+}
+
+func workloadIdentityCredential(c *Config, resource string) (*adal.ServicePrincipalToken, error) {
+	file := os.Getenv(federatedTokenFileEnv)
+	if file == "" {
+		return nil, errors.New(federatedTokenFileEnv + " must be set to use workload identity")
+	}
+	return c.federatedToken(resource, func() (string, error) {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(b)), nil
+	})
+}
+
+// federatedToken exchanges the assertion returned by jwt for an AAD token for resource.
+// jwt is invoked on every refresh, so it may return a different assertion each time.
+func (c *Config) federatedToken(resource string, jwt adal.JWTCallback) (*adal.ServicePrincipalToken, error) {
+	if c.usesMSAL() {
+		return c.msalCredential(confidential.NewCredFromAssertionCallback(func(context.Context, confidential.AssertionRequestOptions) (string, error) {
+			return jwt()
+		}), resource)
+	}
+	oauthConfig, err := c.confidentialOAuthConfig()
+	if err != nil {
+		return nil, err
+	}
+	return adal.NewServicePrincipalTokenFromFederatedTokenCallback(*oauthConfig, c.clientID(), jwt, resource)
+}
+
+// WithGitHubActions selects GitHub Actions OIDC federation as the credential source.
+// The job must have the id-token: write permission, and the client ID and tenant must identify an
+// app registration with a federated credential trusting the repository.
+func WithGitHubActions() Option {
+	return func(c *Config) {
+		c.credential = githubActionsCredential
+	}
+}
+
+func githubActionsCredential(c *Config, resource string) (*adal.ServicePrincipalToken, error) {
+	requestURL, requestToken := os.Getenv(githubTokenURLEnv), os.Getenv(githubTokenTokenEnv)
+	if requestURL == "" || requestToken == "" {
+		return nil, errors.New(githubTokenURLEnv + " and " + githubTokenTokenEnv + " must be set, is the id-token: write permission granted?")
+	}
+	u, err := url.Parse(requestURL)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	q.Set("audience", federatedAudience)
+	u.RawQuery = q.Encode()
+
+	return c.federatedToken(resource, func() (string, error) {
+		req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Authorization", "bearer "+requestToken)
+		resp, err := c.httpClient().Do(req)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("github actions id token request failed with status %d", resp.StatusCode)
+		}
+		var out struct {
+			Value string `json:"value"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+			return "", err
+		}
+		return out.Value, nil
+	})
+}
+
+// WithGitLabCI selects GitLab CI OIDC federation as the credential source.
+// variable names the environment variable holding the ID token, as declared under id_tokens in
+// .gitlab-ci.yml with an aud of api://AzureADTokenExchange. When empty, CI_JOB_JWT_V2 is used.
+func WithGitLabCI(variable string) Option {
+	if variable == "" {
+		variable = gitlabJobJWTEnv
+	}
+	return func(c *Config) {
+		c.credential = func(c *Config, resource string) (*adal.ServicePrincipalToken, error) {
+			if os.Getenv(variable) == "" {
+				return nil, errors.New(variable + " must be set to use gitlab ci federation")
+			}
+			return c.federatedToken(resource, func() (string, error) {
+				return os.Getenv(variable), nil
+			})
+		}
+	}
+}
+
+// WithGoogleCloudFederation selects Google Cloud federation as the credential source for GCE and GKE workloads.
+// The metadata server's identity token for the instance's service account is exchanged as a federated
+// client assertion, so the app registration must trust https://accounts.google.com for that account.
+func WithGoogleCloudFederation() Option {
+	return func(c *Config) {
+		c.credential = func(c *Config, resource string) (*adal.ServicePrincipalToken, error) {
+			return c.federatedToken(resource, c.gceIdentityToken)
+		}
+	}
+}
+
+// gceIdentityToken fetches an identity token for the federated audience from the GCE metadata server.
+func (c *Config) gceIdentityToken() (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), gceTimeout)
+	defer cancel()
+	q := url.Values{}
+	q.Set("audience", federatedAudience)
+	q.Set("format", "full")
+	req, err := http.NewRequest(http.MethodGet, gceIdentityEndpoint+"?"+q.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := c.httpClient().Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("gce metadata identity request failed with status %d: %s", resp.StatusCode, body)
+	}
+	return strings.TrimSpace(string(body)), nil
+}
+
+// AssertionRequest describes the client assertion a ClientAssertionFunc must produce.
+type AssertionRequest struct {
+	// ClientID is the application the assertion authenticates, used as its iss and sub claims.
+	ClientID string
+	// TokenEndpoint is the endpoint the assertion is presented to, used as its aud claim.
+	TokenEndpoint string
+}
+
+// ClientAssertionFunc returns a signed client assertion JWT for the request.
+type ClientAssertionFunc func(request AssertionRequest) (string, error)
+
+// WithClientAssertion selects a caller-produced client assertion as the credential source, for assertions
+// signed by an HSM or an external signing service. assertion is called for every token request.
+func WithClientAssertion(assertion ClientAssertionFunc) Option {
+	return func(c *Config) {
+		c.credential = func(c *Config, resource string) (*adal.ServicePrincipalToken, error) {
+			oauthConfig, err := c.confidentialOAuthConfig()
+			if err != nil {
+				return nil, err
+			}
+			request := AssertionRequest{
+				ClientID:      c.clientID(),
+				TokenEndpoint: oauthConfig.TokenEndpoint.String(),
+			}
+			return c.federatedToken(resource, func() (string, error) {
+				return assertion(request)
+			})
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package azauth
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/Azure/go-autorest/autorest"
+)
+
+// autorestClientType is the type generated SDK clients embed to send requests.
+var autorestClientType = reflect.TypeOf(autorest.Client{})
+
+// Authorize authorizes a generated SDK client for management operations and returns it, so clients can be
+// constructed and authorized in one line:
+//
+//	vms, err := azauth.Authorize(config, compute.NewVirtualMachinesClient(subscriptionID))
+func Authorize[T any](c *Config, client T) (T, error) {
+	if err := c.AuthorizeAny(&client); err != nil {
+		return client, err
+	}
+	return client, nil
+}
+
+// AuthorizeAny authorizes client, a pointer to a generated SDK client, for management operations.
+func (c *Config) AuthorizeAny(client interface{}) error {
+	return c.AuthorizeAnyForResource(client, c.armResource())
+}
+
+// AuthorizeAnyForResource finds the autorest.Client embedded in client, a pointer to a generated SDK client,
+// and authorizes it using AuthorizeClientForResource.
+func (c *Config) AuthorizeAnyForResource(client interface{}, resource string) error {
+	inner, err := embeddedClient(client)
+	if err != nil {
+		return err
+	}
+	return c.AuthorizeClientForResource(inner, resource)
+}
+
+// embeddedClient returns the autorest.Client embedded in the struct client points to, searching
+// embedded fields breadth first as Go resolves promoted fields. Pointers to pointers are followed, since
+// Authorize passes a pointer to clients that are pointers themselves.
+func embeddedClient(client interface{}) (*autorest.Client, error) {
+	v := reflect.ValueOf(client)
+	for v.Kind() == reflect.Ptr && !v.IsNil() && v.Elem().Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("client must be a non-nil pointer to a struct, got %T", client)
+	}
+	if v.Elem().Type() == autorestClientType {
+		return v.Interface().(*autorest.Client), nil
+	}
+	level := []reflect.Value{v.Elem()}
+	for len(level) > 0 {
+		var next []reflect.Value
+		for _, s := range level {
+			for i := 0; i < s.NumField(); i++ {
+				field := s.Type().Field(i)
+				if !field.Anonymous || !field.IsExported() {
+					continue
+				}
+				f := s.Field(i)
+				if f.Kind() == reflect.Ptr {
+					if f.IsNil() {
+						continue
+					}
+					f = f.Elem()
+				}
+				if f.Type() == autorestClientType {
+					return f.Addr().Interface().(*autorest.Client), nil
+				}
+				if f.Kind() == reflect.Struct {
+					next = append(next, f)
+				}
+			}
+		}
+		level = next
+	}
+	return nil, errors.New("client does not embed an autorest.Client")
+}
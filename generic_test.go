@@ -0,0 +1,97 @@
+package azauth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+)
+
+// BaseClient and vmClient mirror the clients generated SDKs declare.
+type BaseClient struct {
+	autorest.Client
+	BaseURI string
+}
+
+type vmClient struct {
+	BaseClient
+}
+
+type pointerClient struct {
+	*autorest.Client
+}
+
+type namedClient struct {
+	Client autorest.Client
+}
+
+func TestEmbeddedClient(t *testing.T) {
+	vm := vmClient{}
+	base := BaseClient{}
+	inner := autorest.Client{}
+	pointer := pointerClient{Client: &inner}
+	vmPointer := &vmClient{}
+	for _, tc := range []struct {
+		name    string
+		client  interface{}
+		want    *autorest.Client
+		wantErr bool
+	}{
+		{name: "embedded", client: &base, want: &base.Client},
+		{name: "nested embedding", client: &vm, want: &vm.Client},
+		{name: "embedded pointer", client: &pointer, want: &inner},
+		{name: "autorest client", client: &inner, want: &inner},
+		{name: "pointer to a pointer", client: &vmPointer, want: &vmPointer.Client},
+		{name: "not a pointer", client: vm, wantErr: true},
+		{name: "nil pointer", client: (*vmClient)(nil), wantErr: true},
+		{name: "named field", client: &namedClient{}, wantErr: true},
+		{name: "nil embedded pointer", client: &pointerClient{}, wantErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := embeddedClient(tc.client)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("embeddedClient() succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.want {
+				t.Errorf("embeddedClient() = %p, want %p", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	c := newTestConfig(t, WithAccessToken("token", time.Now().Add(time.Hour), nil))
+
+	t.Run("value", func(t *testing.T) {
+		client, err := Authorize(c, vmClient{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if client.Authorizer == nil {
+			t.Error("the returned client isn't authorized")
+		}
+	})
+
+	t.Run("pointer", func(t *testing.T) {
+		in := &vmClient{}
+		client, err := Authorize(c, in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if client != in || in.Authorizer == nil {
+			t.Error("the client pointed to isn't authorized")
+		}
+	})
+
+	t.Run("no autorest client", func(t *testing.T) {
+		if _, err := Authorize(c, namedClient{}); err == nil {
+			t.Error("Authorize() succeeded for a client without an embedded autorest.Client")
+		}
+	})
+}
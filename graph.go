@@ -0,0 +1,81 @@
+package azauth
+
+import (
+	"fmt"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/azure"
+)
+
+// GraphAPI selects between the two directory APIs, which have different audiences and endpoints in every cloud.
+type GraphAPI int
+
+const (
+	// MicrosoftGraph is the Microsoft Graph API, e.g. https://graph.microsoft.com in the public cloud,
+	// https://graph.microsoft.us in US Government, and https://microsoftgraph.chinacloudapi.cn in China.
+	MicrosoftGraph GraphAPI = iota
+	// AADGraph is the deprecated Azure AD Graph API, e.g. https://graph.windows.net in the public cloud
+	// and https://graph.chinacloudapi.cn in China.
+	AADGraph
+)
+
+// String implements fmt.Stringer.
+func (g GraphAPI) String() string {
+	if g == AADGraph {
+		return "Azure AD Graph"
+	}
+	return "Microsoft Graph"
+}
+
+// GraphResource returns the audience of tokens for api in the Config's cloud. Using the public cloud's
+// audience in a sovereign cloud is a common cause of 401s.
+func (c *Config) GraphResource(api GraphAPI) (string, error) {
+	resource := c.env.ResourceIdentifiers.MicrosoftGraph
+	if api == AADGraph {
+		resource = c.env.ResourceIdentifiers.Graph
+	}
+	if resource == "" || resource == azure.NotAvailable {
+		return "", fmt.Errorf("%s is not available in %s", api, c.env.Name)
+	}
+	return resource, nil
+}
+
+// GraphEndpoint returns the base URL of api in the Config's cloud.
+func (c *Config) GraphEndpoint(api GraphAPI) (string, error) {
+	endpoint := c.env.MicrosoftGraphEndpoint
+	if api == AADGraph {
+		endpoint = c.env.GraphEndpoint
+	}
+	if endpoint == "" || endpoint == azure.NotAvailable {
+		// environments built from metadata may only carry the audience, which is also the endpoint.
+		resource, err := c.GraphResource(api)
+		if err != nil {
+			return "", err
+		}
+		endpoint = resource
+	}
+	return withSlash(endpoint), nil
+}
+
+// GraphScope returns the .default scope of api in the Config's cloud, which requests every permission granted
+// to the application. Pass it with TokenCredential to Graph SDKs, which otherwise default to the public cloud:
+//
+//	scope, err := config.GraphScope(azauth.MicrosoftGraph)
+//	client, err := msgraphsdk.NewGraphServiceClientWithCredentials(config.TokenCredential(), []string{scope})
+func (c *Config) GraphScope(api GraphAPI) (string, error) {
+	resource, err := c.GraphResource(api)
+	if err != nil {
+		return "", err
+	}
+	return scopeForResource(resource), nil
+}
+
+// AuthorizeGraphClient authorizes client for api in the Config's cloud, e.g. the autorest.Client of a
+// graphrbac client for AADGraph. Construct the client with GraphEndpoint as its base URI.
+func (c *Config) AuthorizeGraphClient(client *autorest.Client, api GraphAPI) error {
+	resource, err := c.GraphResource(api)
+	if err != nil {
+		return err
+	}
+	return c.AuthorizeClientForResource(client, resource)
+}
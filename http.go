@@ -0,0 +1,73 @@
+package azauth
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"sync"
+
+	"github.com/Azure/go-autorest/autorest/adal"
+)
+
+const (
+	// maxIdleConnsPerHost keeps enough connections to each token service alive for services that acquire
+	// tokens for many resources and tenants at once; net/http keeps only two by default.
+	maxIdleConnsPerHost = 32
+	// maxPooledBuffer is the largest buffer returned to the pool, so one huge response isn't retained.
+	maxPooledBuffer = 64 << 10
+)
+
+// tokenClient sends the token requests azauth makes itself. Its transport is shared by every Config,
+// so connections to token services are reused across them.
+var tokenClient = &http.Client{Transport: func() http.RoundTripper {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = maxIdleConnsPerHost
+	return transport
+}()}
+
+// WithTLSConfig sends token requests to AAD, ADFS, and managed identity endpoints with config, e.g. to trust
+// a private CA in front of a proxied or on-premises token service. It applies to MSAL and adal as well as
+// azauth's own requests.
+func WithTLSConfig(config *tls.Config) Option {
+	return func(c *Config) {
+		transport := tokenClient.Transport.(*http.Transport).Clone()
+		transport.TLSClientConfig = config
+		c.client = &http.Client{Transport: transport}
+	}
+}
+
+// WithRootCAs trusts the certificate authorities in pool, instead of the system's, for token requests.
+func WithRootCAs(pool *x509.CertPool) Option {
+	return WithTLSConfig(&tls.Config{RootCAs: pool})
+}
+
+// httpClient returns the client token requests are sent with.
+func (c *Config) httpClient() *http.Client {
+	if c.client != nil {
+		return c.client
+	}
+	return tokenClient
+}
+
+// useClient sends the refreshes of spt, when handled by adal, with the configured client.
+func (c *Config) useClient(spt *adal.ServicePrincipalToken) {
+	if c.client != nil {
+		spt.SetSender(c.client)
+	}
+}
+
+// buffers pools the response bodies of token requests.
+var buffers = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+
+func getBuffer() *bytes.Buffer {
+	buf := buffers.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() <= maxPooledBuffer {
+		buffers.Put(buf)
+	}
+}
@@ -0,0 +1,76 @@
+package azauth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/adal"
+)
+
+// newTestConfig returns a Config built with opts, closed when the test ends.
+func newTestConfig(tb testing.TB, opts ...Option) *Config {
+	tb.Helper()
+	c, err := New(opts...)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { c.Close() })
+	return c
+}
+
+// tokenServer returns a server issuing a token for every request it receives, closed when the test ends.
+func tokenServer(tb testing.TB) *httptest.Server {
+	tb.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"access_token":"token","token_type":"Bearer","expires_in":"3600"}`)
+	}))
+	tb.Cleanup(srv.Close)
+	return srv
+}
+
+func BenchmarkGetAuthorizerForResource(b *testing.B) {
+	c := newTestConfig(b, WithAccessToken("token", time.Now().Add(time.Hour), nil))
+	resources := make([]string, 100)
+	for i := range resources {
+		resources[i] = fmt.Sprintf("https://resource%d.example.com", i)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			if _, err := c.GetAuthorizerForResource(resources[i%len(resources)]); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+func BenchmarkRequestToken(b *testing.B) {
+	srv := tokenServer(b)
+	c := newTestConfig(b)
+	oauthConfig, err := adal.NewOAuthConfig(srv.URL, "tenant")
+	if err != nil {
+		b.Fatal(err)
+	}
+	ctx := context.Background()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := c.requestToken(ctx, *oauthConfig, url.Values{
+			"grant_type":    {"client_credentials"},
+			"client_id":     {"client"},
+			"client_secret": {"secret"},
+			"resource":      {"https://resource.example.com"},
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package azauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/adal"
+)
+
+// browserTimeout bounds how long the interactive browser flow waits for the redirect.
+const browserTimeout = 5 * time.Minute
+
+// acquireUserToken prompts a user for a token for resource.
+type acquireUserToken func(c *Config, oauthConfig adal.OAuthConfig, clientID, resource string) (*adal.Token, error)
+
+// userCredential prompts the user through acquire once, and serves later resources from the refresh token it returned.
+// With a TokenStore configured, the refresh token is loaded from and saved to the store, so the user is only
+// prompted again when the stored token is missing or no longer redeemable.
+func userCredential(acquire acquireUserToken) credential {
+	// mu guards the refresh token, and is never held across network requests or prompts.
+	var mu sync.Mutex
+	// promptMu serializes prompts, so the user is shown one at a time while other resources keep refreshing.
+	var promptMu sync.Mutex
+	var refreshToken string
+	var stored bool
+	return func(c *Config, resource string) (*adal.ServicePrincipalToken, error) {
+		clientID := c.clientID()
+		if clientID == "" {
+			return nil, errors.New("a client ID must be provided for interactive authentication")
+		}
+		oauthConfig, err := c.oauthConfig()
+		if err != nil {
+			return nil, err
+		}
+		key := c.tenantID() + "|" + clientID
+
+		// prompt acquires a token interactively and keeps its refresh token, unless another prompt already
+		// replaced failed, the refresh token that couldn't be used, in which case it returns no token.
+		prompt := func(resource, failed string) (*adal.Token, error) {
+			promptMu.Lock()
+			defer promptMu.Unlock()
+			mu.Lock()
+			current := refreshToken
+			mu.Unlock()
+			if current != failed {
+				return nil, nil
+			}
+			token, err := acquire(c, *oauthConfig, clientID, resource)
+			if err != nil {
+				return nil, err
+			}
+			mu.Lock()
+			refreshToken, stored = token.RefreshToken, false
+			mu.Unlock()
+			c.saveRefreshToken(key, token.RefreshToken)
+			return token, nil
+		}
+
+		// redeem acquires a token with the refresh token. When a stored refresh token is rejected, it is
+		// returned as failed so the user can be prompted.
+		redeem := func(ctx context.Context, resource string) (token *adal.Token, failed string, err error) {
+			mu.Lock()
+			current, fromStore := refreshToken, stored
+			mu.Unlock()
+			token, err = c.requestToken(ctx, *oauthConfig, url.Values{
+				"grant_type":    {"refresh_token"},
+				"client_id":     {clientID},
+				"refresh_token": {current},
+				"resource":      {resource},
+			})
+			if err != nil {
+				if fromStore {
+					failed = current
+				}
+				return nil, failed, err
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if refreshToken != current {
+				// another refresh or prompt replaced the token meanwhile.
+				return token, "", nil
+			}
+			stored = false
+			if token.RefreshToken != "" && token.RefreshToken != refreshToken {
+				refreshToken = token.RefreshToken
+				c.saveRefreshToken(key, refreshToken)
+			}
+			return token, "", nil
+		}
+
+		mu.Lock()
+		if refreshToken == "" {
+			refreshToken, stored = c.loadRefreshToken(key), true
+		}
+		current := refreshToken
+		mu.Unlock()
+		var initial *adal.Token
+		if current == "" {
+			if initial, err = prompt(resource, ""); err != nil {
+				return nil, err
+			}
+		}
+
+		return newCustomToken(oauthConfig.TokenEndpoint, resource, func(ctx context.Context, resource string) (*adal.Token, error) {
+			mu.Lock()
+			if initial != nil {
+				token := initial
+				initial = nil
+				mu.Unlock()
+				return token, nil
+			}
+			mu.Unlock()
+			token, failed, err := redeem(ctx, resource)
+			if err == nil || failed == "" {
+				return token, err
+			}
+			// the stored token may have expired or been revoked since it was saved.
+			if token, err := prompt(resource, failed); token != nil || err != nil {
+				return token, err
+			}
+			token, _, err = redeem(ctx, resource)
+			return token, err
+		})
+	}
+}
+
+// WithDeviceCode selects the device code flow as the credential source.
+// callback receives the user code and verification URL to present to the user;
+// the token endpoint is polled until the user completes sign in.
+// The user is only prompted once, later resources are acquired with the resulting refresh token.
+func WithDeviceCode(callback func(code adal.DeviceCode)) Option {
+	return func(c *Config) {
+		c.credential = userCredential(func(c *Config, oauthConfig adal.OAuthConfig, clientID, resource string) (*adal.Token, error) {
+			code, err := adal.InitiateDeviceAuth(c.httpClient(), oauthConfig, clientID, resource)
+			if err != nil {
+				return nil, err
+			}
+			callback(*code)
+			return adal.WaitForUserCompletion(c.httpClient(), code)
+		})
+	}
+}
+
+// WithInteractiveBrowser selects the authorization code flow through the system browser as the credential source.
+// A listener on a random localhost port receives the redirect, so the client ID must be a public client
+// registered with http://localhost as a redirect URI.
+// The user is only prompted once, later resources are acquired with the resulting refresh token.
+//
+// Sign in isn't brokered through the Windows Web Account Manager (WAM), so it can't satisfy conditional
+// access policies requiring a compliant device. Use WithAzureCLI on such devices.
+func WithInteractiveBrowser() Option {
+	return func(c *Config) {
+		c.credential = userCredential(browserToken)
+	}
+}
+
+func browserToken(c *Config, oauthConfig adal.OAuthConfig, clientID, resource string) (*adal.Token, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	defer listener.Close()
+	redirectURI := fmt.Sprintf("http://localhost:%d", listener.Addr().(*net.TCPAddr).Port)
+
+	state, err := randomString(16)
+	if err != nil {
+		return nil, err
+	}
+	verifier, err := randomString(32)
+	if err != nil {
+		return nil, err
+	}
+	challenge := sha256.Sum256([]byte(verifier))
+
+	authorizeURL := oauthConfig.AuthorizeEndpoint
+	q := url.Values{}
+	q.Set("client_id", clientID)
+	q.Set("response_type", "code")
+	q.Set("redirect_uri", redirectURI)
+	q.Set("resource", resource)
+	q.Set("state", state)
+	q.Set("prompt", "select_account")
+	q.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	q.Set("code_challenge_method", "S256")
+	toV2(authorizeURL, q)
+	authorizeURL.RawQuery = q.Encode()
+
+	// only the first redirect is used, later ones, e.g. from a reloaded page, are answered without blocking.
+	type redirect struct {
+		code string
+		err  error
+	}
+	redirects := make(chan redirect, 1)
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if query.Get("state") != state {
+			http.Error(w, "invalid state", http.StatusBadRequest)
+			return
+		}
+		result := redirect{code: query.Get("code")}
+		if query.Get("error") != "" {
+			result.err = fmt.Errorf("authorization failed: %s: %s", query.Get("error"), query.Get("error_description"))
+		}
+		select {
+		case redirects <- result:
+		default:
+			fmt.Fprintln(w, "Authentication has already completed, you can close this window.")
+			return
+		}
+		if result.err != nil {
+			fmt.Fprintln(w, "Authentication failed, you can close this window.")
+			return
+		}
+		fmt.Fprintln(w, "Authentication complete, you can close this window.")
+	})}
+	go server.Serve(listener)
+	defer server.Close()
+
+	if err := openBrowser(authorizeURL.String()); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), browserTimeout)
+	defer cancel()
+	select {
+	case result := <-redirects:
+		if result.err != nil {
+			return nil, result.err
+		}
+		v := url.Values{}
+		v.Set("grant_type", "authorization_code")
+		v.Set("client_id", clientID)
+		v.Set("code", result.code)
+		v.Set("redirect_uri", redirectURI)
+		v.Set("resource", resource)
+		v.Set("code_verifier", verifier)
+		return c.requestToken(ctx, oauthConfig, v)
+	case <-ctx.Done():
+		return nil, errors.New("timed out waiting for interactive browser authentication")
+	}
+}
+
+// openBrowser opens target in the system browser.
+var openBrowser = func(target string) error {
+	switch runtime.GOOS {
+	case "windows":
+		return exec.Command("rundll32", "url.dll,FileProtocolHandler", target).Start()
+	case "darwin":
+		return exec.Command("open", target).Start()
+	default:
+		return exec.Command("xdg-open", target).Start()
+	}
+}
+
+// randomString returns n random bytes encoded for use in URLs.
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
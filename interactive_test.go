@@ -0,0 +1,185 @@
+package azauth
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/adal"
+	"github.com/Azure/go-autorest/autorest/azure"
+)
+
+// memoryTokenStore is a TokenStore kept in memory.
+type memoryTokenStore struct {
+	mu     sync.Mutex
+	tokens map[string]string
+}
+
+func (s *memoryTokenStore) Load(key string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tokens[key], nil
+}
+
+func (s *memoryTokenStore) Save(key, refreshToken string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens[key] = refreshToken
+	return nil
+}
+
+// refreshTokenServer returns a token endpoint redeeming only the refresh token valid, and the environment
+// addressing it.
+func refreshTokenServer(t *testing.T, valid string) azure.Environment {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil || r.Form.Get("refresh_token") != valid {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		fmt.Fprintf(w, `{"access_token":"redeemed-%s","refresh_token":%q,"expires_in":"3600"}`, r.Form.Get("resource"), valid)
+	}))
+	t.Cleanup(srv.Close)
+	env := azure.PublicCloud
+	env.ActiveDirectoryEndpoint = srv.URL + "/"
+	return env
+}
+
+// promptToken returns the token of a prompt for resource, with refresh token rt.
+func promptToken(resource, rt string) *adal.Token {
+	return &adal.Token{
+		AccessToken:  "prompted-" + resource,
+		RefreshToken: rt,
+		ExpiresOn:    json.Number(strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)),
+		Resource:     resource,
+		Type:         "Bearer",
+	}
+}
+
+func TestUserCredential(t *testing.T) {
+	tests := []struct {
+		name    string
+		stored  string
+		prompts int32
+	}{
+		{name: "no stored token", prompts: 1},
+		{name: "usable stored token", stored: "rt", prompts: 0},
+		{name: "rejected stored token", stored: "revoked", prompts: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := refreshTokenServer(t, "rt")
+			store := &memoryTokenStore{tokens: map[string]string{}}
+			if tt.stored != "" {
+				store.tokens["tenant|client"] = tt.stored
+			}
+			var prompts atomic.Int32
+			cred := userCredential(func(c *Config, oauthConfig adal.OAuthConfig, clientID, resource string) (*adal.Token, error) {
+				prompts.Add(1)
+				// keep the prompt open while other resources try to refresh.
+				time.Sleep(50 * time.Millisecond)
+				return promptToken(resource, "rt"), nil
+			})
+			c := newTestConfig(t, WithAzureEnvironment(env), Tenant("tenant"), App("client"), WithTokenStore(store))
+
+			var wg sync.WaitGroup
+			tokens := make([]string, 8)
+			errs := make([]error, len(tokens))
+			for i := range tokens {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					resource := fmt.Sprintf("https://resource%d.example.com", i)
+					spt, err := cred(c, resource)
+					if err == nil {
+						err = spt.Refresh()
+					}
+					if err != nil {
+						errs[i] = err
+						return
+					}
+					tokens[i] = spt.OAuthToken()
+				}(i)
+			}
+			wg.Wait()
+
+			prompted := 0
+			for i, token := range tokens {
+				if errs[i] != nil {
+					t.Fatalf("resource %d: %v", i, errs[i])
+				}
+				if token == fmt.Sprintf("prompted-https://resource%d.example.com", i) {
+					prompted++
+				} else if token != fmt.Sprintf("redeemed-https://resource%d.example.com", i) {
+					t.Errorf("resource %d: unexpected token %q", i, token)
+				}
+			}
+			if got := prompts.Load(); got != tt.prompts {
+				t.Errorf("prompted %d times, want %d", got, tt.prompts)
+			}
+			if prompted > int(tt.prompts) {
+				t.Errorf("%d resources were served a prompt's token, want at most %d", prompted, tt.prompts)
+			}
+			if got := store.tokens["tenant|client"]; got != "rt" {
+				t.Errorf("stored refresh token %q, want rt", got)
+			}
+		})
+	}
+}
+
+func TestBrowserTokenAnswersRepeatedRedirects(t *testing.T) {
+	aad := newAADServer(t)
+	c := newTestConfig(t, append(aad.options(), Tenant("tenant"))...)
+	oauthConfig, err := c.oauthConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var replies []string
+	defer func(open func(string) error) { openBrowser = open }(openBrowser)
+	openBrowser = func(target string) error {
+		authorize, err := url.Parse(target)
+		if err != nil {
+			return err
+		}
+		redirect := authorize.Query().Get("redirect_uri") + "/?" + url.Values{"code": {"code"}, "state": {authorize.Query().Get("state")}}.Encode()
+		// the second redirect, e.g. from a reloaded page, arrives before the first is redeemed.
+		client := &http.Client{Timeout: 5 * time.Second}
+		for i := 0; i < 2; i++ {
+			resp, err := client.Get(redirect)
+			if err != nil {
+				return err
+			}
+			body, err := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err != nil {
+				return err
+			}
+			replies = append(replies, string(body))
+		}
+		return nil
+	}
+
+	token, err := browserToken(c, *oauthConfig, "client", "https://resource.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.AccessToken != "token" {
+		t.Errorf("token %q, want the redeemed one", token.AccessToken)
+	}
+	if got := aad.tokenRequests(); got != 1 {
+		t.Errorf("token requests = %d, want 1", got)
+	}
+	if len(replies) != 2 || !strings.Contains(replies[0], "complete") || !strings.Contains(replies[1], "already") {
+		t.Errorf("replies = %q, want the sign in completed then already completed", replies)
+	}
+}
@@ -0,0 +1,64 @@
+//go:build !windows
+
+package azauth
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// readKeychain returns the secret stored for service and account in the OS keychain,
+// through the macOS security tool or the freedesktop Secret Service on Linux.
+func readKeychain(ctx context.Context, service, account string) (string, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "darwin" {
+		cmd = exec.CommandContext(ctx, "security", "find-generic-password", "-s", service, "-a", account, "-w")
+	} else {
+		cmd = exec.CommandContext(ctx, "secret-tool", "lookup", "service", service, "account", account)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && keychainNotFound(exitErr.ExitCode(), stderr.String()) {
+			return "", errNotInKeychain
+		}
+		return "", fmt.Errorf("failed to read %s from the keychain: %v: %s", service, err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// keychainNotFound reports whether a keychain lookup that exited with code and stderr found no entry:
+// security exits with errSecItemNotFound, while secret-tool exits with 1 and prints nothing.
+func keychainNotFound(code int, stderr string) bool {
+	if runtime.GOOS == "darwin" {
+		return code == 44
+	}
+	return code == 1 && strings.TrimSpace(stderr) == ""
+}
+
+// writeKeychain stores secret for service and account in the OS keychain, replacing any existing secret.
+func writeKeychain(ctx context.Context, service, account, secret string) error {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "darwin" {
+		// with -w last and no value, security prompts for the secret and its confirmation on stdin, keeping
+		// it out of the process arguments other users can read.
+		cmd = exec.CommandContext(ctx, "security", "add-generic-password", "-U", "-s", service, "-a", account, "-w")
+		cmd.Stdin = strings.NewReader(secret + "\n" + secret + "\n")
+	} else {
+		cmd = exec.CommandContext(ctx, "secret-tool", "store", "--label="+service, "service", service, "account", account)
+		cmd.Stdin = strings.NewReader(secret)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to write %s to the keychain: %v: %s", service, err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
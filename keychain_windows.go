@@ -0,0 +1,90 @@
+package azauth
+
+import (
+	"context"
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	// credTypeGeneric is CRED_TYPE_GENERIC.
+	credTypeGeneric = 1
+	// credPersistLocalMachine is CRED_PERSIST_LOCAL_MACHINE, which keeps credentials across logon sessions.
+	credPersistLocalMachine = 2
+	// errorNotFound is ERROR_NOT_FOUND, returned by CredRead for missing credentials.
+	errorNotFound syscall.Errno = 1168
+)
+
+var (
+	advapi32      = syscall.NewLazyDLL("advapi32.dll")
+	procCredRead  = advapi32.NewProc("CredReadW")
+	procCredWrite = advapi32.NewProc("CredWriteW")
+	procCredFree  = advapi32.NewProc("CredFree")
+)
+
+// winCredential mirrors CREDENTIALW.
+type winCredential struct {
+	Flags              uint32
+	Type               uint32
+	TargetName         *uint16
+	Comment            *uint16
+	LastWritten        syscall.Filetime
+	CredentialBlobSize uint32
+	CredentialBlob     *byte
+	Persist            uint32
+	AttributeCount     uint32
+	Attributes         uintptr
+	TargetAlias        *uint16
+	UserName           *uint16
+}
+
+// readKeychain returns the secret stored for service and account in the Windows Credential Manager,
+// under the service/account target name keytar uses.
+func readKeychain(_ context.Context, service, account string) (string, error) {
+	target, err := syscall.UTF16PtrFromString(service + "/" + account)
+	if err != nil {
+		return "", err
+	}
+	var cred *winCredential
+	if ret, _, err := procCredRead.Call(uintptr(unsafe.Pointer(target)), credTypeGeneric, 0, uintptr(unsafe.Pointer(&cred))); ret == 0 {
+		if err == errorNotFound {
+			return "", errNotInKeychain
+		}
+		return "", fmt.Errorf("failed to read %s from the credential manager: %v", service, err)
+	}
+	defer procCredFree.Call(uintptr(unsafe.Pointer(cred)))
+	if cred.CredentialBlobSize == 0 {
+		return "", nil
+	}
+	blob := make([]byte, cred.CredentialBlobSize)
+	copy(blob, (*[1 << 20]byte)(unsafe.Pointer(cred.CredentialBlob))[:cred.CredentialBlobSize:cred.CredentialBlobSize])
+	return string(blob), nil
+}
+
+// writeKeychain stores secret for service and account in the Windows Credential Manager, replacing any existing secret.
+func writeKeychain(_ context.Context, service, account, secret string) error {
+	target, err := syscall.UTF16PtrFromString(service + "/" + account)
+	if err != nil {
+		return err
+	}
+	user, err := syscall.UTF16PtrFromString(account)
+	if err != nil {
+		return err
+	}
+	blob := []byte(secret)
+	cred := winCredential{
+		Type:               credTypeGeneric,
+		TargetName:         target,
+		CredentialBlobSize: uint32(len(blob)),
+		Persist:            credPersistLocalMachine,
+		UserName:           user,
+	}
+	if len(blob) > 0 {
+		cred.CredentialBlob = &blob[0]
+	}
+	if ret, _, err := procCredWrite.Call(uintptr(unsafe.Pointer(&cred)), 0); ret == 0 {
+		return fmt.Errorf("failed to write %s to the credential manager: %v", service, err)
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package azauth
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/adal"
+	"github.com/Azure/go-autorest/autorest/azure"
+	"github.com/Azure/go-autorest/autorest/azure/auth"
+)
+
+const (
+	// keyVaultAPIVersion is the Key Vault data plane API version used to read secrets.
+	keyVaultAPIVersion = "7.4"
+
+	// keyVaultReferencePrefix marks client secrets that name a Key Vault secret rather than holding a value,
+	// in the form keyvault://<vault>/secrets/<name>[/<version>].
+	keyVaultReferencePrefix = "keyvault://"
+)
+
+// WithKeyVaultCertificate selects a client certificate stored in Azure Key Vault as the credential source.
+// The certificate and its private key are read from the vault's secret of the same name using the host's
+// managed identity, so they never touch disk. They are re-read on every token refresh to pick up rotations.
+// vaultURL is the vault's base URL, e.g. https://myvault.vault.azure.net.
+func WithKeyVaultCertificate(vaultURL, certificateName string) Option {
+	return func(c *Config) {
+		c.credential = func(c *Config, resource string) (*adal.ServicePrincipalToken, error) {
+			oauthConfig, err := c.confidentialOAuthConfig()
+			if err != nil {
+				return nil, err
+			}
+			return c.federatedToken(resource, func() (string, error) {
+				value, contentType, err := c.keyVaultSecret(vaultURL, certificateName)
+				if err != nil {
+					return "", err
+				}
+				data := []byte(value)
+				if contentType == "application/x-pkcs12" {
+					if data, err = base64.StdEncoding.DecodeString(value); err != nil {
+						return "", err
+					}
+				}
+				chain, key, err := parseCertificate(data, "")
+				if err != nil {
+					return "", err
+				}
+				return signAssertion(chain, key, oauthConfig.TokenEndpoint.String(), c.clientID())
+			})
+		}
+	}
+}
+
+// resolveSecretReferences replaces a client secret of the form keyvault://<vault>/secrets/<name>, set with
+// Key, WithClientSecret, or AZURE_CLIENT_SECRET, with the value of the referenced secret.
+// The vault is read with the host's managed identity.
+func (c *Config) resolveSecretReferences() error {
+	if c.key == "" && c.credential == nil && strings.HasPrefix(c.setting(auth.ClientSecret), keyVaultReferencePrefix) {
+		// resolve the reference once into the client secret credential, rather than on every authorization.
+		c.app, c.key, c.tenant = c.clientID(), c.setting(auth.ClientSecret), c.tenantID()
+		c.credential = clientSecretCredential
+	}
+	if !strings.HasPrefix(c.key, keyVaultReferencePrefix) {
+		return nil
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(c.key, keyVaultReferencePrefix), "/", 3)
+	if len(parts) != 3 || parts[1] != "secrets" || parts[0] == "" || parts[2] == "" {
+		return fmt.Errorf("invalid key vault secret reference %q, expected keyvault://<vault>/secrets/<name>", c.key)
+	}
+	vaultURL := fmt.Sprintf("https://%s.%s", parts[0], c.env.KeyVaultDNSSuffix)
+	value, _, err := c.keyVaultSecret(vaultURL, parts[2])
+	if err != nil {
+		return fmt.Errorf("failed to resolve client secret from key vault: %v", err)
+	}
+	c.key = value
+	return nil
+}
+
+// AuthorizeKeyVaultClient authorizes client, the autorest.Client of a Key Vault data plane client, for the
+// Key Vault audience of the Config's cloud, e.g. https://vault.usgovcloudapi.net in Azure Government.
+func (c *Config) AuthorizeKeyVaultClient(client *autorest.Client) error {
+	return c.AuthorizeClientForResource(client, c.keyVaultResource())
+}
+
+// managedHSMSuffixes maps clouds to the Managed HSM DNS suffix of clouds whose environments don't carry it.
+var managedHSMSuffixes = map[string]string{
+	azure.USGovernmentCloud.Name: "managedhsm.usgovcloudapi.net",
+	azure.ChinaCloud.Name:        "managedhsm.azure.cn",
+}
+
+// managedHSMSuffix returns the Managed HSM DNS suffix of env, or the empty string when it is unknown.
+func managedHSMSuffix(env azure.Environment) string {
+	if env.ManagedHSMDNSSuffix != "" && env.ManagedHSMDNSSuffix != azure.NotAvailable {
+		return env.ManagedHSMDNSSuffix
+	}
+	return managedHSMSuffixes[env.Name]
+}
+
+// managedHSMAudience returns the Managed HSM audience of env, derived from its DNS suffix when the
+// environment has none, or the empty string when Managed HSM is unknown in env.
+func managedHSMAudience(env azure.Environment) string {
+	if env.ResourceIdentifiers.ManagedHSM != "" && env.ResourceIdentifiers.ManagedHSM != azure.NotAvailable {
+		return strings.TrimSuffix(env.ResourceIdentifiers.ManagedHSM, "/")
+	}
+	if suffix := managedHSMSuffix(env); suffix != "" {
+		return "https://" + strings.Trim(suffix, "./")
+	}
+	return ""
+}
+
+// ManagedHSMResource returns the audience of Managed HSM tokens in the Config's cloud, e.g.
+// https://managedhsm.azure.net in the public cloud. Managed HSMs reject tokens for the Key Vault audience.
+func (c *Config) ManagedHSMResource() (string, error) {
+	return c.serviceResource("Managed HSM", managedHSMAudience(*c.env))
+}
+
+// ManagedHSMEndpoint returns the endpoint of the Managed HSM name in the Config's cloud, e.g.
+// https://myhsm.managedhsm.azure.net/ in the public cloud.
+func (c *Config) ManagedHSMEndpoint(name string) (string, error) {
+	suffix := managedHSMSuffix(*c.env)
+	if suffix == "" {
+		return "", fmt.Errorf("Managed HSM is not available in %s", c.env.Name)
+	}
+	return "https://" + name + "." + strings.Trim(suffix, "./") + "/", nil
+}
+
+// AuthorizeManagedHSMClient authorizes client, the autorest.Client of a Key Vault data plane client used with
+// a Managed HSM, for ManagedHSMResource.
+func (c *Config) AuthorizeManagedHSMClient(client *autorest.Client) error {
+	resource, err := c.ManagedHSMResource()
+	if err != nil {
+		return err
+	}
+	return c.AuthorizeClientForResource(client, resource)
+}
+
+// keyVaultSecret reads a secret from vaultURL with managed identity. name may carry a /<version> suffix.
+// It returns the secret's value and content type.
+func (c *Config) keyVaultSecret(vaultURL, name string) (string, string, error) {
+	spt, err := managedIdentityCredential(c, c.keyVaultResource())
+	if err != nil {
+		return "", "", err
+	}
+	if err := spt.EnsureFresh(); err != nil {
+		return "", "", err
+	}
+
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/secrets/%s?api-version=%s", strings.TrimSuffix(vaultURL, "/"), name, keyVaultAPIVersion), nil)
+	if err != nil {
+		return "", "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+spt.OAuthToken())
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", "", fmt.Errorf("reading key vault secret %s failed with status %d: %s", name, resp.StatusCode, body)
+	}
+	var secret struct {
+		Value       string `json:"value"`
+		ContentType string `json:"contentType"`
+	}
+	if err := json.Unmarshal(body, &secret); err != nil {
+		return "", "", err
+	}
+	return secret.Value, secret.ContentType, nil
+}
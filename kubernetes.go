@@ -0,0 +1,91 @@
+package azauth
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/adal"
+)
+
+// AKSServerAppID is the audience of the AKS-managed AAD integration, shared by all AAD-enabled AKS clusters.
+const AKSServerAppID = "6dae42f8-4368-4678-94ff-3960e28e3630"
+
+// KubernetesTransportWrapper returns a wrapper that authenticates Kubernetes API requests with a bearer token
+// for serverAppID, the AKS server application, AKSServerAppID when empty. Its signature matches the
+// WrapTransport field of a client-go rest.Config, so AAD-enabled clusters can be reached with:
+//
+//	restConfig.WrapTransport = wrapper
+//
+// The token is refreshed before it expires, so the rest.Config must not also set BearerToken or an exec plugin.
+func (c *Config) KubernetesTransportWrapper(serverAppID string) (func(http.RoundTripper) http.RoundTripper, error) {
+	if serverAppID == "" {
+		serverAppID = AKSServerAppID
+	}
+	spt, err := c.GetServicePrincipalTokenForResource(serverAppID)
+	if err != nil {
+		return nil, err
+	}
+	return func(next http.RoundTripper) http.RoundTripper {
+		return &bearerRoundTripper{spt: spt, next: next}
+	}, nil
+}
+
+// execCredential is a client.authentication.k8s.io/v1 ExecCredential.
+type execCredential struct {
+	Kind       string               `json:"kind"`
+	APIVersion string               `json:"apiVersion"`
+	Spec       execCredentialSpec   `json:"spec"`
+	Status     execCredentialStatus `json:"status"`
+}
+
+type execCredentialSpec struct {
+	Interactive bool `json:"interactive"`
+}
+
+type execCredentialStatus struct {
+	ExpirationTimestamp string `json:"expirationTimestamp"`
+	Token               string `json:"token"`
+}
+
+// ExecCredential returns a client.authentication.k8s.io/v1 ExecCredential carrying a token for serverAppID,
+// AKSServerAppID when empty, as printed by kubeconfig exec credential plugins. The azauth command's
+// exec-credential mode prints it, so azauth can be used as a plugin directly.
+func (c *Config) ExecCredential(serverAppID string) ([]byte, error) {
+	if serverAppID == "" {
+		serverAppID = AKSServerAppID
+	}
+	spt, err := c.GetServicePrincipalTokenForResource(serverAppID)
+	if err != nil {
+		return nil, err
+	}
+	if err := spt.EnsureFresh(); err != nil {
+		return nil, err
+	}
+	token := spt.Token()
+	return json.Marshal(execCredential{
+		Kind:       "ExecCredential",
+		APIVersion: "client.authentication.k8s.io/v1",
+		Status: execCredentialStatus{
+			ExpirationTimestamp: token.Expires().UTC().Format(time.RFC3339),
+			Token:               token.AccessToken,
+		},
+	})
+}
+
+// bearerRoundTripper sets a fresh bearer token on every request before passing it to next.
+type bearerRoundTripper struct {
+	spt  *adal.ServicePrincipalToken
+	next http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (b *bearerRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := b.spt.EnsureFreshWithContext(req.Context()); err != nil {
+		return nil, err
+	}
+	// round trippers must not modify the caller's request.
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+b.spt.OAuthToken())
+	return b.next.RoundTrip(req)
+}
@@ -0,0 +1,36 @@
+package azauth
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// KustoResource returns the audience of tokens for the Azure Data Explorer cluster at clusterURI, e.g.
+// https://mycluster.westus.kusto.windows.net. Every cluster is its own audience, so the URI is reduced to
+// its scheme and host: paths, such as a database, ports, and trailing slashes would request tokens the
+// cluster rejects.
+func KustoResource(clusterURI string) (string, error) {
+	u, err := url.Parse(clusterURI)
+	if err != nil {
+		return "", err
+	}
+	if !strings.EqualFold(u.Scheme, "https") || u.Hostname() == "" {
+		return "", fmt.Errorf("cluster URI %q must be an https URL", clusterURI)
+	}
+	return "https://" + strings.ToLower(u.Hostname()), nil
+}
+
+// KustoAccessToken returns a fresh access token for the Azure Data Explorer cluster at clusterURI. The
+// azure-kusto-go connection string builder can also authenticate with the Config's TokenCredential, which
+// serves the scope the SDK requests for the cluster from the same cached tokens:
+//
+//	kcsb := kusto.NewConnectionStringBuilder(clusterURI).WithTokenCredential(config.TokenCredential())
+func (c *Config) KustoAccessToken(ctx context.Context, clusterURI string) (string, error) {
+	resource, err := KustoResource(clusterURI)
+	if err != nil {
+		return "", err
+	}
+	return c.accessToken(ctx, resource)
+}
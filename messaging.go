@@ -0,0 +1,72 @@
+package azauth
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Azure/go-autorest/autorest/azure"
+)
+
+// CBSTokenProvider provides AAD tokens for claims-based security (CBS) authorization of AMQP connections to
+// Event Hubs and Service Bus, backed by the Config's cached tokens. The Track 1 messaging SDKs take an
+// azure-amqp-common-go auth.TokenProvider, so azauth doesn't depend on them; adapt it in a few lines:
+//
+//	type tokenProvider struct{ *azauth.CBSTokenProvider }
+//
+//	func (p tokenProvider) GetToken(uri string) (*auth.Token, error) {
+//		token, expiry, err := p.Token(uri)
+//		if err != nil {
+//			return nil, err
+//		}
+//		return auth.NewToken(auth.CBSTokenTypeJWT, token, expiry), nil
+//	}
+//
+// Track 2 SDKs authenticate with TokenCredential instead.
+type CBSTokenProvider struct {
+	c        *Config
+	service  string
+	resource string
+}
+
+// EventHubsTokenProvider returns a CBSTokenProvider for Event Hubs namespaces in the Config's cloud. Event
+// Hubs accepts tokens for the Service Bus audience of the cloud, e.g. https://servicebus.azure.net/.
+func (c *Config) EventHubsTokenProvider() *CBSTokenProvider {
+	return &CBSTokenProvider{c: c, service: "Event Hubs", resource: c.env.ResourceIdentifiers.ServiceBus}
+}
+
+// ServiceBusTokenProvider returns a CBSTokenProvider for Service Bus namespaces in the Config's cloud, for
+// queue, topic, and subscription clients of the Track 1 Service Bus SDK:
+//
+//	ns, err := servicebus.NewNamespace(servicebus.NamespaceWithTokenProvider(tokenProvider{config.ServiceBusTokenProvider()}))
+func (c *Config) ServiceBusTokenProvider() *CBSTokenProvider {
+	return &CBSTokenProvider{c: c, service: "Service Bus", resource: c.env.ResourceIdentifiers.ServiceBus}
+}
+
+// ServiceBusNamespace returns the fully qualified name of the Service Bus or Event Hubs namespace in the
+// Config's cloud, e.g. myns.servicebus.windows.net in the public cloud, so Track 2 clients are constructed
+// in one call:
+//
+//	client, err := azservicebus.NewClient(config.ServiceBusNamespace("myns"), config.TokenCredential(), nil)
+func (c *Config) ServiceBusNamespace(namespace string) string {
+	return namespace + "." + strings.TrimPrefix(c.env.ServiceBusEndpointSuffix, ".")
+}
+
+// Token returns a token authorizing the CBS put-token request for uri, the entity being accessed, and its
+// expiry in Unix seconds, as CBS expects. Tokens are for the namespace's audience rather than uri, and
+// authorize every entity the identity has been granted access to.
+func (p *CBSTokenProvider) Token(uri string) (token, expiry string, err error) {
+	if p.resource == "" || p.resource == azure.NotAvailable {
+		return "", "", fmt.Errorf("%s AAD authorization is not available in %s", p.service, p.c.env.Name)
+	}
+	spt, err := p.c.cachedToken(p.resource)
+	if err != nil {
+		return "", "", err
+	}
+	if err := spt.EnsureFreshWithContext(context.Background()); err != nil {
+		return "", "", err
+	}
+	t := spt.Token()
+	return t.AccessToken, strconv.FormatInt(t.Expires().Unix(), 10), nil
+}
@@ -0,0 +1,219 @@
+package azauth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/azure"
+)
+
+const (
+	// metadataAPIVersion selects the metadata format listing suffixes and resource IDs.
+	metadataAPIVersion = "2022-09-01"
+	// legacyMetadataAPIVersion selects the original format, the only one Azure Stack Hub serves.
+	legacyMetadataAPIVersion = "1.0"
+	// metadataTimeout bounds the discovery of the cloud in New.
+	metadataTimeout = 30 * time.Second
+)
+
+// cloudMetadata is an entry of the metadata/endpoints response.
+type cloudMetadata struct {
+	Name            string `json:"name"`
+	Portal          string `json:"portal"`
+	ResourceManager string `json:"resourceManager"`
+	Authentication  struct {
+		LoginEndpoint    string   `json:"loginEndpoint"`
+		Audiences        []string `json:"audiences"`
+		IdentityProvider string   `json:"identityProvider"`
+	} `json:"authentication"`
+	Graph                    string `json:"graph"`
+	GraphAudience            string `json:"graphAudience"`
+	MicrosoftGraphResourceID string `json:"microsoftGraphResourceId"`
+	Batch                    string `json:"batch"`
+	ActiveDirectoryDataLake  string `json:"activeDirectoryDataLake"`
+	LogAnalyticsResourceID   string `json:"logAnalyticsResourceId"`
+	OSSRDBMSResourceID       string `json:"ossrDbmsResourceId"`
+	SynapseResourceID        string `json:"synapseAnalyticsResourceId"`
+	Suffixes                 struct {
+		Storage        string `json:"storage"`
+		KeyVaultDNS    string `json:"keyVaultDns"`
+		ManagedHSMDNS  string `json:"mhsmDns"`
+		ACRLoginServer string `json:"acrLoginServer"`
+		SQLServer      string `json:"sqlServerHostname"`
+		MySQL          string `json:"mysqlServerEndpoint"`
+		PostgreSQL     string `json:"postgresqlServerEndpoint"`
+		MariaDB        string `json:"mariadbServerEndpoint"`
+		Synapse        string `json:"synapseAnalytics"`
+		DataLakeStore  string `json:"azureDataLakeStoreFileSystem"`
+	} `json:"suffixes"`
+}
+
+// legacyCloudMetadata is the metadata/endpoints response of api-version 1.0.
+type legacyCloudMetadata struct {
+	GalleryEndpoint string `json:"galleryEndpoint"`
+	GraphEndpoint   string `json:"graphEndpoint"`
+	PortalEndpoint  string `json:"portalEndpoint"`
+	Authentication  struct {
+		LoginEndpoint string   `json:"loginEndpoint"`
+		Audiences     []string `json:"audiences"`
+	} `json:"authentication"`
+}
+
+// EnvironmentFromMetadata builds the environment of the cloud whose Resource Manager is armEndpoint from
+// its metadata/endpoints document, for Azure Stack Hub and clouds azure has no built in environment for.
+// Endpoints the metadata doesn't describe, such as Azure Stack Hub's Key Vault, are derived from the
+// Resource Manager's domain as azure.EnvironmentFromURL does.
+func EnvironmentFromMetadata(ctx context.Context, armEndpoint string) (azure.Environment, error) {
+	return environmentFromMetadata(ctx, tokenClient, armEndpoint)
+}
+
+// WithEnvironmentFromMetadata selects the cloud whose Resource Manager is armEndpoint, discovered by New
+// as EnvironmentFromMetadata does. The metadata is requested with the Config's client, so a stamp serving
+// it with a private CA is trusted with WithTLSConfig or WithRootCAs. Failed discovery fails New.
+func WithEnvironmentFromMetadata(armEndpoint string) Option {
+	return func(c *Config) {
+		c.metadataEndpoint = armEndpoint
+	}
+}
+
+// discoverEnvironment selects the cloud described by the metadata of the Config's metadata endpoint.
+func (c *Config) discoverEnvironment() error {
+	ctx, cancel := context.WithTimeout(context.Background(), metadataTimeout)
+	defer cancel()
+	env, err := environmentFromMetadata(ctx, c.httpClient(), c.metadataEndpoint)
+	if err != nil {
+		return err
+	}
+	c.env = &env
+	return nil
+}
+
+// environmentFromMetadata builds the environment of armEndpoint from metadata requested with client.
+func environmentFromMetadata(ctx context.Context, client *http.Client, armEndpoint string) (azure.Environment, error) {
+	armEndpoint = strings.TrimSuffix(armEndpoint, "/") + "/"
+	var clouds []cloudMetadata
+	if err := getMetadata(ctx, client, armEndpoint, metadataAPIVersion, &clouds); err == nil && len(clouds) > 0 {
+		cloud := clouds[0]
+		for _, candidate := range clouds {
+			if strings.EqualFold(strings.TrimSuffix(candidate.ResourceManager, "/")+"/", armEndpoint) {
+				cloud = candidate
+			}
+		}
+		return environmentFromCloud(armEndpoint, cloud), nil
+	}
+
+	var legacy legacyCloudMetadata
+	if err := getMetadata(ctx, client, armEndpoint, legacyMetadataAPIVersion, &legacy); err != nil {
+		return azure.Environment{}, err
+	}
+	if legacy.Authentication.LoginEndpoint == "" || len(legacy.Authentication.Audiences) == 0 {
+		return azure.Environment{}, fmt.Errorf("the metadata of %s has no authentication endpoint", armEndpoint)
+	}
+	env := stampEnvironment(armEndpoint)
+	env.ActiveDirectoryEndpoint = withSlash(legacy.Authentication.LoginEndpoint)
+	env.TokenAudience = legacy.Authentication.Audiences[0]
+	env.ServiceManagementEndpoint = legacy.Authentication.Audiences[0]
+	env.ManagementPortalURL = legacy.PortalEndpoint
+	env.GalleryEndpoint = legacy.GalleryEndpoint
+	env.GraphEndpoint = legacy.GraphEndpoint
+	env.ResourceIdentifiers.Graph = legacy.GraphEndpoint
+	return env, nil
+}
+
+// getMetadata decodes the metadata/endpoints document of armEndpoint at apiVersion, requested with client, into v.
+func getMetadata(ctx context.Context, client *http.Client, armEndpoint, apiVersion string, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, armEndpoint+"metadata/endpoints?api-version="+apiVersion, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("metadata request to %s failed with status %d", req.URL.Host, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// stampEnvironment returns an environment for armEndpoint with the suffixes of an Azure Stack Hub stamp,
+// whose services share the domain of its Resource Manager, e.g. local.azurestack.external.
+func stampEnvironment(armEndpoint string) azure.Environment {
+	host := strings.TrimSuffix(strings.TrimPrefix(strings.TrimPrefix(armEndpoint, "https://"), "http://"), "/")
+	suffix := host
+	if i := strings.Index(host, "."); i >= 0 {
+		suffix = host[i+1:]
+	}
+	keyVaultSuffix := "vault." + suffix
+	return azure.Environment{
+		Name:                    "HybridEnvironment",
+		ResourceManagerEndpoint: armEndpoint,
+		StorageEndpointSuffix:   suffix,
+		KeyVaultDNSSuffix:       keyVaultSuffix,
+		KeyVaultEndpoint:        "https://" + keyVaultSuffix + "/",
+		ResourceIdentifiers: azure.ResourceIdentifier{
+			KeyVault: "https://" + keyVaultSuffix,
+		},
+	}
+}
+
+// environmentFromCloud converts cloud metadata into an environment.
+func environmentFromCloud(armEndpoint string, cloud cloudMetadata) azure.Environment {
+	env := stampEnvironment(armEndpoint)
+	if cloud.Name != "" {
+		env.Name = cloud.Name
+	}
+	env.ManagementPortalURL = cloud.Portal
+	env.ActiveDirectoryEndpoint = withSlash(cloud.Authentication.LoginEndpoint)
+	if audiences := cloud.Authentication.Audiences; len(audiences) > 0 {
+		env.ServiceManagementEndpoint = audiences[0]
+		env.TokenAudience = audiences[len(audiences)-1]
+	}
+	env.GraphEndpoint = cloud.Graph
+	env.MicrosoftGraphEndpoint = cloud.MicrosoftGraphResourceID
+	env.BatchManagementEndpoint = cloud.Batch
+	if s := cloud.Suffixes.Storage; s != "" {
+		env.StorageEndpointSuffix = s
+	}
+	if s := cloud.Suffixes.KeyVaultDNS; s != "" {
+		env.KeyVaultDNSSuffix = s
+		env.KeyVaultEndpoint = "https://" + s + "/"
+		env.ResourceIdentifiers.KeyVault = "https://" + s
+	}
+	if s := cloud.Suffixes.ManagedHSMDNS; s != "" {
+		env.ManagedHSMDNSSuffix = s
+		env.ManagedHSMEndpoint = "https://" + s + "/"
+		env.ResourceIdentifiers.ManagedHSM = "https://" + s
+	}
+	env.ContainerRegistryDNSSuffix = cloud.Suffixes.ACRLoginServer
+	env.SQLDatabaseDNSSuffix = cloud.Suffixes.SQLServer
+	env.MySQLDatabaseDNSSuffix = cloud.Suffixes.MySQL
+	env.PostgresqlDatabaseDNSSuffix = cloud.Suffixes.PostgreSQL
+	env.MariaDBDNSSuffix = cloud.Suffixes.MariaDB
+	env.SynapseEndpointSuffix = cloud.Suffixes.Synapse
+	env.DatalakeSuffix = cloud.Suffixes.DataLakeStore
+	env.ResourceIdentifiers.Graph = cloud.GraphAudience
+	env.ResourceIdentifiers.MicrosoftGraph = cloud.MicrosoftGraphResourceID
+	env.ResourceIdentifiers.Batch = cloud.Batch
+	env.ResourceIdentifiers.Datalake = cloud.ActiveDirectoryDataLake
+	env.ResourceIdentifiers.OperationalInsights = cloud.LogAnalyticsResourceID
+	env.ResourceIdentifiers.OSSRDBMS = cloud.OSSRDBMSResourceID
+	env.ResourceIdentifiers.Synapse = cloud.SynapseResourceID
+	if cloud.Suffixes.SQLServer != "" {
+		env.ResourceIdentifiers.SQLDatabase = "https://" + cloud.Suffixes.SQLServer + "/"
+	}
+	return env
+}
+
+// withSlash returns endpoint with a trailing slash, as azure environments spell endpoints.
+func withSlash(endpoint string) string {
+	if endpoint == "" {
+		return ""
+	}
+	return strings.TrimSuffix(endpoint, "/") + "/"
+}
@@ -0,0 +1,114 @@
+package azauth
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// metadataServer returns a TLS server serving metadata/endpoints in the current or legacy format, closed
+// when the test ends.
+func metadataServer(t *testing.T, legacy bool) *httptest.Server {
+	t.Helper()
+	var srv *httptest.Server
+	srv = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/metadata/endpoints" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		switch version := r.URL.Query().Get("api-version"); {
+		case version == metadataAPIVersion && !legacy:
+			fmt.Fprintf(w, `[{"name":"Stamp","resourceManager":"%s/","authentication":{"loginEndpoint":"https://login.stamp","audiences":["https://management.stamp/","https://management.stamp/app"]},"suffixes":{"storage":"stamp.storage","keyVaultDns":"vault.stamp"}}]`, srv.URL)
+		case version == legacyMetadataAPIVersion:
+			fmt.Fprint(w, `{"portalEndpoint":"https://portal.stamp/","authentication":{"loginEndpoint":"https://adfs.stamp/adfs","audiences":["https://management.adfs.stamp/app"]}}`)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestEnvironmentFromMetadata(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		legacy       bool
+		wantName     string
+		wantLogin    string
+		wantAudience string
+	}{
+		{
+			name:         "current",
+			wantName:     "Stamp",
+			wantLogin:    "https://login.stamp/",
+			wantAudience: "https://management.stamp/app",
+		},
+		{
+			name:         "legacy",
+			legacy:       true,
+			wantName:     "HybridEnvironment",
+			wantLogin:    "https://adfs.stamp/adfs/",
+			wantAudience: "https://management.adfs.stamp/app",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := metadataServer(t, tc.legacy)
+			env, err := environmentFromMetadata(context.Background(), srv.Client(), srv.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if env.Name != tc.wantName {
+				t.Errorf("Name = %q, want %q", env.Name, tc.wantName)
+			}
+			if env.ActiveDirectoryEndpoint != tc.wantLogin {
+				t.Errorf("ActiveDirectoryEndpoint = %q, want %q", env.ActiveDirectoryEndpoint, tc.wantLogin)
+			}
+			if env.TokenAudience != tc.wantAudience {
+				t.Errorf("TokenAudience = %q, want %q", env.TokenAudience, tc.wantAudience)
+			}
+			if env.ResourceManagerEndpoint != srv.URL+"/" {
+				t.Errorf("ResourceManagerEndpoint = %q, want %q", env.ResourceManagerEndpoint, srv.URL+"/")
+			}
+		})
+	}
+}
+
+func TestWithEnvironmentFromMetadata(t *testing.T) {
+	srv := metadataServer(t, false)
+	for _, tc := range []struct {
+		name    string
+		opts    []Option
+		wantErr bool
+	}{
+		{name: "untrusted certificate", wantErr: true},
+		{name: "trusted with root CAs", opts: []Option{WithRootCAs(trust(srv))}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c, err := New(append([]Option{WithEnvironmentFromMetadata(srv.URL)}, tc.opts...)...)
+			if tc.wantErr {
+				if err == nil {
+					c.Close()
+					t.Fatal("New succeeded with a metadata endpoint serving an untrusted certificate")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			if got := c.Environment().Name; got != "Stamp" {
+				t.Errorf("Environment().Name = %q, want Stamp", got)
+			}
+		})
+	}
+}
+
+// trust returns a pool trusting the certificate of srv.
+func trust(srv *httptest.Server) *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(srv.Certificate())
+	return pool
+}
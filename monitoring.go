@@ -0,0 +1,68 @@
+package azauth
+
+import (
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/azure"
+)
+
+// logAnalyticsAudiences maps clouds to the Log Analytics audience of clouds whose environments don't carry it.
+var logAnalyticsAudiences = map[string]string{
+	azure.ChinaCloud.Name: "https://api.loganalytics.azure.cn",
+}
+
+// logAnalyticsAudience returns the Log Analytics query API audience of env, or the empty string when it is
+// unknown.
+func logAnalyticsAudience(env azure.Environment) string {
+	if id := env.ResourceIdentifiers.OperationalInsights; id != "" && id != azure.NotAvailable {
+		return id
+	}
+	return logAnalyticsAudiences[env.Name]
+}
+
+// LogAnalyticsResource returns the audience of the Log Analytics query API in the Config's cloud, e.g.
+// https://api.loganalytics.io in the public cloud and https://api.loganalytics.us in US Government. The API
+// is served from the audience, so KQL queries are posted to <resource>/v1/workspaces/<workspace ID>/query.
+func (c *Config) LogAnalyticsResource() (string, error) {
+	return c.serviceResource("the Log Analytics query API", logAnalyticsAudience(*c.env))
+}
+
+// AuthorizeLogAnalyticsClient authorizes client, the autorest.Client of a Log Analytics query client, for
+// LogAnalyticsResource.
+func (c *Config) AuthorizeLogAnalyticsClient(client *autorest.Client) error {
+	resource, err := c.LogAnalyticsResource()
+	if err != nil {
+		return err
+	}
+	return c.AuthorizeClientForResource(client, resource)
+}
+
+// applicationInsightsAudiences maps clouds to the audience of the Application Insights API, which environments
+// don't carry.
+var applicationInsightsAudiences = map[string]string{
+	azure.PublicCloud.Name:       "https://api.applicationinsights.io",
+	azure.USGovernmentCloud.Name: "https://api.applicationinsights.us",
+	azure.ChinaCloud.Name:        "https://api.applicationinsights.azure.cn",
+}
+
+// applicationInsightsAudience returns the Application Insights API audience of env, or the empty string
+// when it is unknown.
+func applicationInsightsAudience(env azure.Environment) string {
+	return applicationInsightsAudiences[env.Name]
+}
+
+// ApplicationInsightsResource returns the audience of the Application Insights REST API in the Config's
+// cloud, e.g. https://api.applicationinsights.io in the public cloud. Like the Log Analytics query API, the
+// API is served from the audience, so queries are posted to <resource>/v1/apps/<application ID>/query.
+func (c *Config) ApplicationInsightsResource() (string, error) {
+	return c.serviceResource("the Application Insights API", applicationInsightsAudience(*c.env))
+}
+
+// AuthorizeApplicationInsightsClient authorizes client, the autorest.Client of an Application Insights
+// query client, for ApplicationInsightsResource.
+func (c *Config) AuthorizeApplicationInsightsClient(client *autorest.Client) error {
+	resource, err := c.ApplicationInsightsResource()
+	if err != nil {
+		return err
+	}
+	return c.AuthorizeClientForResource(client, resource)
+}
@@ -0,0 +1,233 @@
+package azauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/adal"
+	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/cache"
+	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/confidential"
+)
+
+// MSALTelemetry describes one token acquisition by an MSAL confidential client.
+type MSALTelemetry struct {
+	// Resource is the resource the token was requested for.
+	Resource string
+	// FromCache reports whether MSAL served the token from its cache rather than AAD.
+	FromCache bool
+	// Duration is how long the acquisition took.
+	Duration time.Duration
+	// Err is the error the acquisition failed with, if any.
+	Err error
+}
+
+// WithConfidentialClientOptions passes opts to the MSAL confidential clients used for client secrets,
+// certificates, federated assertions, and the on-behalf-of flow, e.g. confidential.WithCache for a
+// custom cache serializer or confidential.WithHTTPClient to tune transport. They are applied after azauth's
+// own options, so they take precedence. A cache set this way replaces the one azauth renews tokens through,
+// so MSAL then serves cached tokens until 5 minutes before they expire, whatever the refresh window.
+func WithConfidentialClientOptions(opts ...confidential.Option) Option {
+	return func(c *Config) {
+		c.msalOptions = append(c.msalOptions, opts...)
+	}
+}
+
+// WithMSALTelemetry registers callback to observe every token acquisition by MSAL confidential clients.
+func WithMSALTelemetry(callback func(MSALTelemetry)) Option {
+	return func(c *Config) {
+		c.msalTelemetry = callback
+	}
+}
+
+// WithoutInstanceDiscovery skips AAD instance discovery, which MSAL performs against the authority before
+// its first token request, for private clouds and disconnected deployments where the discovery endpoint is
+// unreachable. The authority is trusted as configured, so it must be correct. Credential sources that don't
+// use MSAL never perform instance discovery.
+func WithoutInstanceDiscovery() Option {
+	return func(c *Config) {
+		c.noInstanceDiscovery = true
+	}
+}
+
+// usesMSAL reports whether confidential client tokens are acquired through MSAL. ADFS and B2C authorities
+// keep using the token endpoints directly.
+func (c *Config) usesMSAL() bool {
+	return c.b2cPolicy == "" && !c.isADFS()
+}
+
+// authority returns the AAD authority of the configured tenant.
+func (c *Config) authority() string {
+	return strings.TrimSuffix(c.env.ActiveDirectoryEndpoint, "/") + "/" + c.tenantID()
+}
+
+// confidentialClient returns an MSAL confidential client authenticating as the configured client ID with cred.
+// MSAL declares the cp1 capability for CAE and routes requests to the regional token service.
+func (c *Config) confidentialClient(cred confidential.Credential) (confidential.Client, error) {
+	var opts []confidential.Option
+	if c.cae {
+		opts = append(opts, confidential.WithClientCapabilities([]string{"cp1"}))
+	}
+	if c.regional {
+		if region := c.detectRegion(); region != "" {
+			opts = append(opts, confidential.WithAzureRegion(region))
+		}
+	}
+	if c.sendX5C {
+		opts = append(opts, confidential.WithX5C())
+	}
+	if c.noInstanceDiscovery {
+		opts = append(opts, confidential.WithInstanceDiscovery(false))
+	}
+	if c.client != nil {
+		opts = append(opts, confidential.WithHTTPClient(c.client))
+	}
+	opts = append(opts, confidential.WithCache(msalCache{unified: c.msalCache}))
+	opts = append(opts, c.msalOptions...)
+	return confidential.New(c.authority(), c.clientID(), cred, opts...)
+}
+
+// msalCredential returns a token for resource acquired with the client credentials grant by an MSAL
+// confidential client authenticating with cred. Claims challenges are passed on to MSAL.
+func (c *Config) msalCredential(cred confidential.Credential, resource string) (*adal.ServicePrincipalToken, error) {
+	client, err := c.confidentialClient(cred)
+	if err != nil {
+		return nil, err
+	}
+	endpoint, err := url.Parse(c.authority())
+	if err != nil {
+		return nil, err
+	}
+	return newCustomToken(*endpoint, resource, func(ctx context.Context, resource string) (*adal.Token, error) {
+		var opts []confidential.AcquireByCredentialOption
+		if claims, _ := ctx.Value(claimsKey{}).(string); claims != "" {
+			opts = append(opts, confidential.WithClaims(claims))
+		}
+		start := time.Now()
+		scopes := []string{scopeForResource(resource)}
+		result, err := client.AcquireTokenByCredential(ctx, scopes, opts...)
+		if err == nil && result.Metadata.TokenSource == confidential.TokenSourceCache && time.Until(result.ExpiresOn) < c.dueWithin(ctx) {
+			// MSAL serves cached tokens until 5 minutes before expiry, so a token due for renewal earlier is acquired anew.
+			result, err = client.AcquireTokenByCredential(context.WithValue(ctx, msalBypassKey{}, c.clientID()), scopes, opts...)
+		}
+		c.reportMSAL(resource, result, start, err)
+		if err != nil {
+			return nil, err
+		}
+		return fromAuthResult(result, resource), nil
+	})
+}
+
+// reportMSAL reports an acquisition started at start to the telemetry callback, if any.
+func (c *Config) reportMSAL(resource string, result confidential.AuthResult, start time.Time, err error) {
+	if c.msalTelemetry == nil {
+		return
+	}
+	c.msalTelemetry(MSALTelemetry{
+		Resource:  resource,
+		FromCache: err == nil && result.Metadata.TokenSource == confidential.TokenSourceCache,
+		Duration:  time.Since(start),
+		Err:       err,
+	})
+}
+
+// clientCredential returns the MSAL credential of the application, its client certificate when
+// configured and its client secret otherwise.
+func (c *Config) clientCredential() (confidential.Credential, error) {
+	if chain, key := c.clientCertificate(); key != nil {
+		return confidential.NewCredFromCert(chain, key)
+	}
+	secret := c.clientSecret()
+	if secret == "" {
+		return confidential.Credential{}, errors.New("a client secret or certificate must be provided")
+	}
+	return confidential.NewCredFromSecret(secret)
+}
+
+// fromAuthResult converts an MSAL result into an adal token for resource.
+func fromAuthResult(result confidential.AuthResult, resource string) *adal.Token {
+	return &adal.Token{
+		AccessToken: result.AccessToken,
+		ExpiresOn:   json.Number(strconv.FormatInt(result.ExpiresOn.Unix(), 10)),
+		Resource:    resource,
+		Type:        "Bearer",
+	}
+}
+
+// msalBypassKey carries the client ID whose access tokens an acquisition must not be served from MSAL's cache.
+type msalBypassKey struct{}
+
+// msalCache is the cache of azauth's MSAL confidential clients, persisted to the unified cache when one is
+// configured. MSAL can't be told to skip its cache for the client credentials grant, so for acquisitions
+// marked with msalBypassKey the client's access tokens are hidden from it instead.
+type msalCache struct {
+	unified cache.ExportReplace
+}
+
+// Replace implements cache.ExportReplace.
+func (m msalCache) Replace(ctx context.Context, u cache.Unmarshaler, hints cache.ReplaceHints) error {
+	clientID, bypass := ctx.Value(msalBypassKey{}).(string)
+	if bypass {
+		if err := u.Unmarshal([]byte("{}")); err != nil {
+			return err
+		}
+		u = withoutAccessTokens{Unmarshaler: u, clientID: clientID}
+	}
+	if m.unified == nil {
+		return nil
+	}
+	return m.unified.Replace(ctx, u, hints)
+}
+
+// Export implements cache.ExportReplace.
+func (m msalCache) Export(ctx context.Context, marshaler cache.Marshaler, hints cache.ExportHints) error {
+	if m.unified == nil {
+		return nil
+	}
+	return m.unified.Export(ctx, marshaler, hints)
+}
+
+// withoutAccessTokens unmarshals a unified cache without the access tokens of clientID. The tokens of other
+// clients and every refresh token are kept, since the cache is written back whole.
+type withoutAccessTokens struct {
+	cache.Unmarshaler
+	clientID string
+}
+
+// Unmarshal implements cache.Unmarshaler.
+func (w withoutAccessTokens) Unmarshal(b []byte) error {
+	var contents map[string]json.RawMessage
+	if err := json.Unmarshal(b, &contents); err != nil {
+		return err
+	}
+	var tokens map[string]json.RawMessage
+	if raw, ok := contents["AccessToken"]; ok {
+		if err := json.Unmarshal(raw, &tokens); err != nil {
+			return err
+		}
+	}
+	for key, raw := range tokens {
+		var token struct {
+			ClientID string `json:"client_id"`
+		}
+		if json.Unmarshal(raw, &token) == nil && strings.EqualFold(token.ClientID, w.clientID) {
+			delete(tokens, key)
+		}
+	}
+	if tokens != nil {
+		raw, err := json.Marshal(tokens)
+		if err != nil {
+			return err
+		}
+		contents["AccessToken"] = raw
+	}
+	b, err := json.Marshal(contents)
+	if err != nil {
+		return err
+	}
+	return w.Unmarshaler.Unmarshal(b)
+}
@@ -0,0 +1,106 @@
+package azauth
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMSALRenewsWithinRefreshWindow(t *testing.T) {
+	// other is the unified cache of another application, which renewals must leave in place.
+	const other = `{"AccessToken":{"other-at":{"home_account_id":"","environment":"login.microsoftonline.com","realm":"tenant","credential_type":"AccessToken","client_id":"other","secret":"other-access-token","target":"https://other/.default","cached_at":"1700000000","expires_on":"4102444800","extended_expires_on":"4102444800"}},` +
+		`"RefreshToken":{"other-rt":{"home_account_id":"uid.utid","environment":"login.microsoftonline.com","credential_type":"RefreshToken","client_id":"other","secret":"other-refresh-token"}}}`
+	for _, tc := range []struct {
+		name    string
+		unified bool
+	}{
+		{name: "in memory cache"},
+		{name: "unified cache", unified: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			aad := newAADServer(t)
+			opts := append(aad.options(), WithClientSecret("tenant", "client", "secret"), WithRefreshWindow(30*time.Minute))
+			path := filepath.Join(t.TempDir(), "msal_token_cache.json")
+			if tc.unified {
+				if err := ioutil.WriteFile(path, []byte(other), 0600); err != nil {
+					t.Fatal(err)
+				}
+				opts = append(opts, WithUnifiedTokenCache(path))
+			}
+			c := newTestConfig(t, opts...)
+			spt, err := c.cachedToken("https://resource.example.com")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// the first token is due within the refresh window, though MSAL would serve it for 5 more minutes.
+			aad.issueFor(10 * time.Minute)
+			if err := spt.EnsureFresh(); err != nil {
+				t.Fatal(err)
+			}
+			aad.issueFor(time.Hour)
+			for i := 0; i < 2; i++ {
+				if err := spt.EnsureFresh(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := aad.tokenRequests(); got != 2 {
+				t.Errorf("token requests = %d, want 2", got)
+			}
+			if expires := spt.Token().Expires(); time.Until(expires) < 30*time.Minute {
+				t.Errorf("token expires in %s, want a renewed token", time.Until(expires).Round(time.Second))
+			}
+
+			if tc.unified {
+				b, err := ioutil.ReadFile(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, secret := range []string{"other-access-token", "other-refresh-token"} {
+					if !strings.Contains(string(b), secret) {
+						t.Errorf("the unified cache lost %s", secret)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestMSALBackgroundRefreshRenews(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		shared bool
+	}{
+		{name: "in memory cache"},
+		{name: "shared token file", shared: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			aad := newAADServer(t)
+			opts := append(aad.options(), WithClientSecret("tenant", "client", "secret"), WithBackgroundRefresh(30*time.Minute))
+			if tc.shared {
+				opts = append(opts, WithSharedTokenFile(filepath.Join(t.TempDir(), "tokens.json")))
+			}
+			c := newTestConfig(t, opts...)
+			spt, err := c.cachedToken("https://resource.example.com")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// the first token is outside the refresh window but within the refresh lead.
+			aad.issueFor(10 * time.Minute)
+			if err := spt.EnsureFresh(); err != nil {
+				t.Fatal(err)
+			}
+			aad.issueFor(time.Hour)
+			c.refreshExpiring()
+			if got := aad.tokenRequests(); got != 2 {
+				t.Errorf("token requests = %d, want 2", got)
+			}
+			if expires := spt.Token().Expires(); time.Until(expires) < 30*time.Minute {
+				t.Errorf("token expires in %s, want a renewed token", time.Until(expires).Round(time.Second))
+			}
+		})
+	}
+}
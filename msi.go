@@ -0,0 +1,480 @@
+package azauth
+
+import (
+	"context"
+	"crypto/sha1"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/adal"
+)
+
+const (
+	identityEndpointEnv = "IDENTITY_ENDPOINT"
+	identityHeaderEnv   = "IDENTITY_HEADER"
+	imdsEndpointEnv     = "IMDS_ENDPOINT"
+	msiEndpointEnv      = "MSI_ENDPOINT"
+	msiSecretEnv        = "MSI_SECRET"
+	identityThumbprint  = "IDENTITY_SERVER_THUMBPRINT"
+	// podIdentityHostEnv points at the NMI proxy of clusters running aad-pod-identity.
+	podIdentityHostEnv = "AZURE_POD_IDENTITY_AUTHORITY_HOST"
+
+	// imdsEndpointOverrideEnv overrides the IMDS address, e.g. for emulators and proxied networks.
+	imdsEndpointOverrideEnv = "AZAUTH_IMDS_ENDPOINT"
+
+	// defaultIMDSEndpoint is the Azure Instance Metadata Service, reachable from VMs and VMSS instances.
+	defaultIMDSEndpoint    = "http://169.254.169.254"
+	imdsMetadataAPIVersion = "2021-02-01"
+	imdsTokenAPIVersion    = "2018-02-01"
+	imdsTokenPath          = "/metadata/identity/oauth2/token"
+	// imdsProbeTimeout bounds each attempt to reach IMDS before concluding it is unavailable.
+	imdsProbeTimeout = 500 * time.Millisecond
+
+	appServiceAPIVersion    = "2019-08-01"
+	arcAPIVersion           = "2020-06-01"
+	serviceFabricAPIVersion = "2019-07-01-preview"
+	// arcMaxKeySize bounds the challenge file HIMDS asks us to read.
+	arcMaxKeySize = 4096
+)
+
+// WithManagedIdentityClientID selects a user-assigned managed identity by client ID.
+// When unset, the system-assigned identity of the VM or VMSS is used.
+func WithManagedIdentityClientID(clientID string) Option {
+	return func(c *Config) {
+		c.msiClientID = clientID
+	}
+}
+
+// WithManagedIdentityResourceID selects a user-assigned managed identity by its ARM resource ID, of the form
+// /subscriptions/<subscription>/resourceGroups/<group>/providers/Microsoft.ManagedIdentity/userAssignedIdentities/<name>.
+func WithManagedIdentityResourceID(resourceID string) Option {
+	return func(c *Config) {
+		c.msiResourceID = resourceID
+	}
+}
+
+// WithManagedIdentityObjectID selects a user-assigned managed identity by the object ID of its service principal.
+func WithManagedIdentityObjectID(objectID string) Option {
+	return func(c *Config) {
+		c.msiObjectID = objectID
+	}
+}
+
+// WithIMDSEndpoint overrides the address of the Azure Instance Metadata Service, http://169.254.169.254 by default,
+// for emulators, tests, and proxied networks. AZAUTH_IMDS_ENDPOINT overrides it without code changes.
+func WithIMDSEndpoint(endpoint string) Option {
+	return func(c *Config) {
+		c.imdsHost = endpoint
+	}
+}
+
+// GetAuthorizerFromMSI fetches an authorizer for resource using the managed identity of the host,
+// through IMDS on Azure VMs, the platform identity endpoint on App Service, Functions, and Container Apps,
+// HIMDS on Azure Arc-enabled servers, the Service Fabric identity endpoint, the Cloud Shell token endpoint,
+// or the aad-pod-identity NMI proxy named by AZURE_POD_IDENTITY_AUTHORITY_HOST.
+// Authorizers for the same resource share a cached token.
+func (c *Config) GetAuthorizerFromMSI(resource string) (autorest.Authorizer, error) {
+	return c.authorizerFrom(SourceManagedIdentity, resource)
+}
+
+// managedIdentityCredential acquires a token from the identity endpoint of the host, bounding each request
+// with the IMDS timeout.
+func managedIdentityCredential(c *Config, resource string) (*adal.ServicePrincipalToken, error) {
+	return c.bounded(managedIdentityToken, c.imdsAcquireTimeout)(c, resource)
+}
+
+// managedIdentityToken acquires a token from the identity endpoint of the hosting environment.
+func managedIdentityToken(c *Config, resource string) (*adal.ServicePrincipalToken, error) {
+	selectors := 0
+	for _, id := range []string{c.msiClientID, c.msiResourceID, c.msiObjectID} {
+		if id != "" {
+			selectors++
+		}
+	}
+	if selectors > 1 {
+		return nil, errors.New("a user-assigned managed identity may be selected by only one of client ID, resource ID, or object ID")
+	}
+	return c.identityPlatform().credential(c, resource)
+}
+
+// identityPlatform is the managed identity endpoint of a hosting environment.
+type identityPlatform struct {
+	// credential acquires tokens from the endpoint.
+	credential credential
+	// configured reports whether the environment names the endpoint, rather than IMDS being assumed.
+	configured bool
+}
+
+// identityPlatform returns the managed identity endpoint of the host, detected from its environment once
+// per Config, since the platform doesn't change while the process runs, and again after Flush or Reload.
+func (c *Config) identityPlatform() identityPlatform {
+	c.msiPlatformMu.Lock()
+	defer c.msiPlatformMu.Unlock()
+	if c.msiPlatform == nil {
+		platform := c.detectIdentityPlatform()
+		c.msiPlatform = &platform
+	}
+	return *c.msiPlatform
+}
+
+// detectIdentityPlatform selects the identity endpoint named by the environment, falling back to IMDS.
+// adal handles IMDS and the legacy MSI_ENDPOINT/MSI_SECRET App Service protocol itself.
+func (c *Config) detectIdentityPlatform() identityPlatform {
+	if endpoint, header, thumbprint, ok := serviceFabricEndpoint(); ok {
+		return identityPlatform{configured: true, credential: func(c *Config, resource string) (*adal.ServicePrincipalToken, error) {
+			return serviceFabricCredential(c, endpoint, header, thumbprint, resource)
+		}}
+	}
+	if endpoint, header, ok := appServiceEndpoint(); ok {
+		return identityPlatform{configured: true, credential: func(c *Config, resource string) (*adal.ServicePrincipalToken, error) {
+			return appServiceCredential(c, endpoint, header, resource)
+		}}
+	}
+	if endpoint, ok := arcEndpoint(); ok {
+		return identityPlatform{configured: true, credential: func(c *Config, resource string) (*adal.ServicePrincipalToken, error) {
+			return arcCredential(c, endpoint, resource)
+		}}
+	}
+	if endpoint, ok := cloudShellEndpoint(); ok {
+		return identityPlatform{configured: true, credential: func(c *Config, resource string) (*adal.ServicePrincipalToken, error) {
+			return cloudShellCredential(c, endpoint, resource)
+		}}
+	}
+	if endpoint, ok := podIdentityEndpoint(); ok {
+		return identityPlatform{configured: true, credential: func(c *Config, resource string) (*adal.ServicePrincipalToken, error) {
+			return imdsCredential(c, endpoint, resource)
+		}}
+	}
+	endpoint := c.imdsEndpoint()
+	return identityPlatform{configured: os.Getenv(msiEndpointEnv) != "", credential: func(c *Config, resource string) (*adal.ServicePrincipalToken, error) {
+		if c.msiObjectID != "" || endpoint != defaultIMDSEndpoint {
+			// adal can neither address identities by object ID nor reach IMDS elsewhere.
+			return imdsCredential(c, endpoint+imdsTokenPath, resource)
+		}
+		return adal.NewServicePrincipalTokenFromManagedIdentity(resource, &adal.ManagedIdentityOptions{
+			ClientID:           c.msiClientID,
+			IdentityResourceID: c.msiResourceID,
+		})
+	}}
+}
+
+// appServiceEndpoint returns the identity endpoint and header secret when running in App Service,
+// Functions, or Container Apps.
+func appServiceEndpoint() (string, string, bool) {
+	endpoint, header := os.Getenv(identityEndpointEnv), os.Getenv(identityHeaderEnv)
+	return endpoint, header, endpoint != "" && header != ""
+}
+
+// appServiceCredential acquires tokens from the App Service platform identity endpoint.
+func appServiceCredential(c *Config, endpoint, header, resource string) (*adal.ServicePrincipalToken, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	q.Set("api-version", appServiceAPIVersion)
+	q.Set("resource", resource)
+	c.setIdentitySelector(q, "principal_id")
+	u.RawQuery = q.Encode()
+
+	return newCustomToken(*u, resource, func(ctx context.Context, _ string) (*adal.Token, error) {
+		req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("X-IDENTITY-HEADER", header)
+		return doTokenRequest(c.httpClient(), req.WithContext(ctx))
+	})
+}
+
+// serviceFabricEndpoint returns the identity endpoint, header secret, and server certificate thumbprint
+// when running in a Service Fabric application with a managed identity.
+func serviceFabricEndpoint() (string, string, string, bool) {
+	endpoint, header, thumbprint := os.Getenv(identityEndpointEnv), os.Getenv(identityHeaderEnv), os.Getenv(identityThumbprint)
+	return endpoint, header, thumbprint, endpoint != "" && header != "" && thumbprint != ""
+}
+
+// serviceFabricCredential acquires tokens from the Service Fabric identity endpoint. The endpoint serves
+// a self-signed certificate, so it is pinned to the thumbprint Service Fabric provides instead of
+// validated against the system roots.
+func serviceFabricCredential(c *Config, endpoint, header, thumbprint, resource string) (*adal.ServicePrincipalToken, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	q.Set("api-version", serviceFabricAPIVersion)
+	q.Set("resource", resource)
+	c.setIdentitySelector(q, "object_id")
+	u.RawQuery = q.Encode()
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				// verification is replaced by the thumbprint check below
+				InsecureSkipVerify: true,
+				VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+					if len(rawCerts) == 0 {
+						return errors.New("service fabric identity endpoint presented no certificate")
+					}
+					sum := sha1.Sum(rawCerts[0])
+					if !strings.EqualFold(hex.EncodeToString(sum[:]), thumbprint) {
+						return fmt.Errorf("service fabric identity endpoint certificate does not match thumbprint %s", thumbprint)
+					}
+					return nil
+				},
+			},
+		},
+	}
+
+	return newCustomToken(*u, resource, func(ctx context.Context, _ string) (*adal.Token, error) {
+		req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("secret", header)
+		return doTokenRequest(client, req.WithContext(ctx))
+	})
+}
+
+// cloudShellEndpoint returns the Cloud Shell token endpoint. Cloud Shell sets MSI_ENDPOINT without
+// the MSI_SECRET that accompanies it on App Service.
+func cloudShellEndpoint() (string, bool) {
+	endpoint := os.Getenv(msiEndpointEnv)
+	return endpoint, endpoint != "" && os.Getenv(msiSecretEnv) == ""
+}
+
+// cloudShellCredential acquires tokens for the signed in Cloud Shell user. Unlike IMDS the endpoint takes
+// the resource as a form body and has no api-version or identity selection.
+func cloudShellCredential(c *Config, endpoint, resource string) (*adal.ServicePrincipalToken, error) {
+	if c.userAssignedIdentity() {
+		return nil, errors.New("cloud shell does not support user-assigned managed identities")
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{}
+	form.Set("resource", resource)
+
+	return newCustomToken(*u, resource, func(ctx context.Context, _ string) (*adal.Token, error) {
+		req, err := http.NewRequest(http.MethodPost, u.String(), strings.NewReader(form.Encode()))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Metadata", "true")
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return doTokenRequest(c.httpClient(), req.WithContext(ctx))
+	})
+}
+
+// podIdentityEndpoint returns the token endpoint of the aad-pod-identity NMI proxy when
+// AZURE_POD_IDENTITY_AUTHORITY_HOST is set. NMI serves the IMDS token API.
+func podIdentityEndpoint() (string, bool) {
+	host := os.Getenv(podIdentityHostEnv)
+	return strings.TrimSuffix(host, "/") + imdsTokenPath, host != ""
+}
+
+// imdsCredential acquires tokens through the IMDS token API served at endpoint.
+func imdsCredential(c *Config, endpoint, resource string) (*adal.ServicePrincipalToken, error) {
+	switch {
+	case c.msiObjectID != "":
+		u, err := url.Parse(endpoint)
+		if err != nil {
+			return nil, err
+		}
+		q := u.Query()
+		q.Set("api-version", imdsTokenAPIVersion)
+		q.Set("resource", resource)
+		c.setIdentitySelector(q, "object_id")
+		u.RawQuery = q.Encode()
+		return newCustomToken(*u, resource, func(ctx context.Context, _ string) (*adal.Token, error) {
+			req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+			if err != nil {
+				return nil, err
+			}
+			req.Header.Set("Metadata", "true")
+			return doTokenRequest(c.httpClient(), req.WithContext(ctx))
+		})
+	case c.msiClientID != "":
+		return adal.NewServicePrincipalTokenFromMSIWithUserAssignedID(endpoint, resource, c.msiClientID)
+	case c.msiResourceID != "":
+		return adal.NewServicePrincipalTokenFromMSIWithIdentityResourceID(endpoint, resource, c.msiResourceID)
+	}
+	return adal.NewServicePrincipalTokenFromMSI(endpoint, resource)
+}
+
+// userAssignedIdentity reports whether a user-assigned managed identity was selected.
+func (c *Config) userAssignedIdentity() bool {
+	return c.msiClientID != "" || c.msiResourceID != "" || c.msiObjectID != ""
+}
+
+// setIdentitySelector adds the selected user-assigned managed identity to token request query q.
+// Endpoints disagree on the name of the object ID parameter, so it is given as objectIDParam.
+func (c *Config) setIdentitySelector(q url.Values, objectIDParam string) {
+	switch {
+	case c.msiObjectID != "":
+		q.Set(objectIDParam, c.msiObjectID)
+	case c.msiClientID != "":
+		q.Set("client_id", c.msiClientID)
+	case c.msiResourceID != "":
+		q.Set("mi_res_id", c.msiResourceID)
+	}
+}
+
+// arcEndpoint returns the HIMDS endpoint when running on an Azure Arc-enabled server.
+// The Arc agent sets both IDENTITY_ENDPOINT and IMDS_ENDPOINT.
+func arcEndpoint() (string, bool) {
+	endpoint := os.Getenv(identityEndpointEnv)
+	return endpoint, endpoint != "" && os.Getenv(imdsEndpointEnv) != ""
+}
+
+// arcCredential acquires tokens from HIMDS. Each request is first rejected with a challenge naming
+// a file only readable by privileged users; its contents authenticate the retried request.
+func arcCredential(c *Config, endpoint, resource string) (*adal.ServicePrincipalToken, error) {
+	if c.userAssignedIdentity() {
+		return nil, errors.New("azure arc does not support user-assigned managed identities")
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	q.Set("api-version", arcAPIVersion)
+	q.Set("resource", resource)
+	u.RawQuery = q.Encode()
+
+	return newCustomToken(*u, resource, func(ctx context.Context, _ string) (*adal.Token, error) {
+		req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		req = req.WithContext(ctx)
+		req.Header.Set("Metadata", "true")
+		resp, err := c.httpClient().Do(req)
+		if err != nil {
+			return nil, err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			return nil, fmt.Errorf("expected a challenge from azure arc identity endpoint, got status %d", resp.StatusCode)
+		}
+		key, err := arcChallengeKey(resp.Header.Get("WWW-Authenticate"))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Basic "+key)
+		return doTokenRequest(c.httpClient(), req)
+	})
+}
+
+// arcChallengeKey reads the key file named by a HIMDS challenge of the form "Basic realm=<path>",
+// refusing files outside the agent's token directory.
+func arcChallengeKey(challenge string) (string, error) {
+	parts := strings.SplitN(challenge, "=", 2)
+	if len(parts) != 2 {
+		return "", fmt.Errorf("unexpected azure arc challenge %q", challenge)
+	}
+	path := parts[1]
+
+	dir := "/var/opt/azcmagent/tokens"
+	if runtime.GOOS == "windows" {
+		dir = filepath.Join(os.Getenv("ProgramData"), "AzureConnectedMachineAgent", "Tokens")
+	}
+	if filepath.Dir(filepath.Clean(path)) != filepath.Clean(dir) || filepath.Ext(path) != ".key" {
+		return "", fmt.Errorf("azure arc challenge file %q is not a key in %s", path, dir)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if info.Size() > arcMaxKeySize {
+		return "", fmt.Errorf("azure arc challenge file %q is larger than %d bytes", path, arcMaxKeySize)
+	}
+	key, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return string(key), nil
+}
+
+// AuthorizeClientFromMSI tries to fetch a managed identity authorizer for management operations and inject it into a client.
+func (c *Config) AuthorizeClientFromMSI(client *autorest.Client) error {
+	return c.AuthorizeClientFromMSIForResource(client, c.armResource())
+}
+
+// AuthorizeClientFromMSIForResource tries to fetch a managed identity authorizer using GetAuthorizerFromMSI and inject it into a client.
+func (c *Config) AuthorizeClientFromMSIForResource(client *autorest.Client, resource string) error {
+	authorizer, err := c.GetAuthorizerFromMSI(resource)
+	if err != nil {
+		return err
+	}
+	return c.inject(client, authorizer)
+}
+
+// imdsEndpoint returns the IMDS address set with WithIMDSEndpoint or AZAUTH_IMDS_ENDPOINT, or the default.
+func (c *Config) imdsEndpoint() string {
+	endpoint := c.imdsHost
+	if endpoint == "" {
+		endpoint = os.Getenv(imdsEndpointOverrideEnv)
+	}
+	if endpoint == "" {
+		return defaultIMDSEndpoint
+	}
+	return strings.TrimSuffix(endpoint, "/")
+}
+
+// probeIMDS reports whether IMDS answers, retrying once after a short timeout. The result is cached until
+// Flush, so hosts outside Azure only wait on the probe once rather than on every authorization.
+func (c *Config) probeIMDS() error {
+	c.imdsProbeMu.Lock()
+	defer c.imdsProbeMu.Unlock()
+	if c.imdsProbed {
+		return c.imdsProbeErr
+	}
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), imdsProbeTimeout)
+		_, err = c.imdsMetadata(ctx, "compute/location")
+		cancel()
+		if err == nil {
+			break
+		}
+	}
+	c.imdsProbed, c.imdsProbeErr = true, err
+	return err
+}
+
+// imdsMetadata reads a text value from the IMDS instance metadata, e.g. compute/location.
+func (c *Config) imdsMetadata(ctx context.Context, path string) (string, error) {
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/metadata/instance/%s?api-version=%s&format=text", c.imdsEndpoint(), path, imdsMetadataAPIVersion), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata", "true")
+	resp, err := c.httpClient().Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("imds metadata request for %s failed with status %d", path, resp.StatusCode)
+	}
+	return strings.TrimSpace(string(body)), nil
+}
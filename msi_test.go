@@ -0,0 +1,59 @@
+package azauth
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// appServiceIdentityServer returns an App Service identity endpoint counting the token requests it serves.
+func appServiceIdentityServer(t *testing.T, requests *int32) string {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-IDENTITY-HEADER") != "header" {
+			http.Error(w, "missing identity header", http.StatusUnauthorized)
+			return
+		}
+		atomic.AddInt32(requests, 1)
+		fmt.Fprintf(w, `{"access_token":"token","expires_on":"%d","token_type":"Bearer"}`, time.Now().Add(time.Hour).Unix())
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+func TestIdentityPlatformDetectedOnce(t *testing.T) {
+	for _, env := range []string{identityThumbprint, imdsEndpointEnv, msiEndpointEnv, msiSecretEnv, podIdentityHostEnv} {
+		t.Setenv(env, "")
+	}
+	var first, second int32
+	t.Setenv(identityEndpointEnv, appServiceIdentityServer(t, &first))
+	t.Setenv(identityHeaderEnv, "header")
+	c := newTestConfig(t)
+
+	acquire := func(resource string) {
+		t.Helper()
+		authorizer, err := c.GetAuthorizerFromMSI(resource)
+		if err != nil {
+			t.Fatal(err)
+		}
+		spt, _ := ServicePrincipalToken(authorizer)
+		if err := spt.EnsureFresh(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	acquire("https://first.example.com")
+	// the environment is read once, so a changed endpoint is only used after a Flush.
+	t.Setenv(identityEndpointEnv, appServiceIdentityServer(t, &second))
+	acquire("https://second.example.com")
+	if got := atomic.LoadInt32(&first); got != 2 {
+		t.Errorf("first endpoint served %d requests, want 2", got)
+	}
+	c.Flush()
+	acquire("https://third.example.com")
+	if got := atomic.LoadInt32(&second); got != 1 {
+		t.Errorf("second endpoint served %d requests after Flush, want 1", got)
+	}
+}
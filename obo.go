@@ -0,0 +1,145 @@
+package azauth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/adal"
+	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/confidential"
+)
+
+// oboGrantType is the grant type of on-behalf-of token requests.
+const oboGrantType = "urn:ietf:params:oauth:grant-type:jwt-bearer"
+
+// GetAuthorizerOnBehalfOf fetches an authorizer for resource acting as the user who presented userAssertion,
+// an access token issued to this application. The application authenticates with the client certificate
+// from WithClientCertificate when set, and its client secret otherwise.
+// Tokens are cached per user assertion and resource.
+func (c *Config) GetAuthorizerOnBehalfOf(userAssertion, resource string) (autorest.Authorizer, error) {
+	sum := sha256.Sum256([]byte(userAssertion))
+	key := hex.EncodeToString(sum[:]) + "|" + resource
+
+	if spt, ok := c.oboToken(key); ok {
+		return c.bearer(spt), nil
+	}
+	// acquire without oboMu, so one user's token request doesn't block every other user's cached tokens.
+	v, err, _ := c.oboInflight.Do(key, func() (interface{}, error) {
+		if spt, ok := c.oboToken(key); ok {
+			return spt, nil
+		}
+		spt, err := c.tokenFrom(func(c *Config, resource string) (*adal.ServicePrincipalToken, error) {
+			return c.onBehalfOfToken(userAssertion, resource)
+		}, resource)
+		if err != nil {
+			return nil, err
+		}
+		return c.storeOBOToken(key, spt), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c.bearer(v.(*adal.ServicePrincipalToken)), nil
+}
+
+// oboToken returns the cached on-behalf-of token of key, dropping it once expired.
+func (c *Config) oboToken(key string) (*adal.ServicePrincipalToken, bool) {
+	c.oboMu.Lock()
+	defer c.oboMu.Unlock()
+	spt, ok := c.oboTokens[key]
+	if ok && oboExpired(spt) {
+		delete(c.oboTokens, key)
+		return nil, false
+	}
+	return spt, ok
+}
+
+// storeOBOToken caches spt as the token of key and returns the cached token, which is an unexpired token
+// stored by another caller in the meantime, if any.
+func (c *Config) storeOBOToken(key string, spt *adal.ServicePrincipalToken) *adal.ServicePrincipalToken {
+	c.oboMu.Lock()
+	defer c.oboMu.Unlock()
+	if c.oboTokens == nil {
+		c.oboTokens = map[string]*adal.ServicePrincipalToken{}
+	}
+	// drop tokens for assertions that can no longer be exchanged so the cache tracks active users.
+	for k, cached := range c.oboTokens {
+		if oboExpired(cached) {
+			delete(c.oboTokens, k)
+		}
+	}
+	if cached, ok := c.oboTokens[key]; ok {
+		return cached
+	}
+	c.oboTokens[key] = spt
+	return spt
+}
+
+// oboExpired reports whether the on-behalf-of token spt has expired, a sign its user assertion has too.
+func oboExpired(spt *adal.ServicePrincipalToken) bool {
+	token := spt.Token()
+	return token.AccessToken != "" && token.IsExpired()
+}
+
+// AuthorizeOnBehalfOf tries to fetch an authorizer using GetAuthorizerOnBehalfOf and inject it into a client.
+func (c *Config) AuthorizeOnBehalfOf(client *autorest.Client, userAssertion, resource string) error {
+	authorizer, err := c.GetAuthorizerOnBehalfOf(userAssertion, resource)
+	if err != nil {
+		return err
+	}
+	return c.inject(client, authorizer)
+}
+
+func (c *Config) onBehalfOfToken(userAssertion, resource string) (*adal.ServicePrincipalToken, error) {
+	clientID := c.clientID()
+	if clientID == "" {
+		return nil, errors.New("a client ID must be provided for the on-behalf-of flow")
+	}
+	oauthConfig, err := c.oauthConfig()
+	if err != nil {
+		return nil, err
+	}
+	if _, key := c.clientCertificate(); key == nil && c.clientSecret() == "" {
+		return nil, errors.New("a client secret or certificate must be provided for the on-behalf-of flow")
+	}
+	if c.usesMSAL() {
+		cred, err := c.clientCredential()
+		if err != nil {
+			return nil, err
+		}
+		client, err := c.confidentialClient(cred)
+		if err != nil {
+			return nil, err
+		}
+		return newCustomToken(oauthConfig.TokenEndpoint, resource, func(ctx context.Context, resource string) (*adal.Token, error) {
+			var opts []confidential.AcquireOnBehalfOfOption
+			if claims, _ := ctx.Value(claimsKey{}).(string); claims != "" {
+				opts = append(opts, confidential.WithClaims(claims))
+			}
+			start := time.Now()
+			result, err := client.AcquireTokenOnBehalfOf(ctx, userAssertion, []string{scopeForResource(resource)}, opts...)
+			c.reportMSAL(resource, result, start, err)
+			if err != nil {
+				return nil, err
+			}
+			return fromAuthResult(result, resource), nil
+		})
+	}
+
+	return newCustomToken(oauthConfig.TokenEndpoint, resource, func(ctx context.Context, resource string) (*adal.Token, error) {
+		v := url.Values{}
+		v.Set("grant_type", oboGrantType)
+		v.Set("client_id", clientID)
+		v.Set("assertion", userAssertion)
+		v.Set("requested_token_use", "on_behalf_of")
+		v.Set("resource", resource)
+		if err := c.setClientCredentials(v, oauthConfig.TokenEndpoint); err != nil {
+			return nil, err
+		}
+		return c.requestToken(ctx, *oauthConfig, v)
+	})
+}
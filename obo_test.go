@@ -0,0 +1,98 @@
+package azauth
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/adal"
+)
+
+func TestGetAuthorizerOnBehalfOfConcurrentCallers(t *testing.T) {
+	setCredentialEnvironment(t, map[string]string{})
+	srv := newAADServer(t)
+	c := newTestConfig(t, append(srv.options(), WithClientSecret("tenant", "client", "secret"))...)
+	resource := c.armResource()
+
+	const callers = 8
+	var wg sync.WaitGroup
+	tokens := make([]*adal.ServicePrincipalToken, callers)
+	errs := make([]error, callers)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			authorizer, err := c.GetAuthorizerOnBehalfOf("user-assertion", resource)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			spt, _ := ServicePrincipalToken(authorizer)
+			tokens[i] = spt
+			errs[i] = spt.EnsureFresh()
+		}(i)
+	}
+	wg.Wait()
+	for i := 0; i < callers; i++ {
+		if errs[i] != nil {
+			t.Fatalf("caller %d: %v", i, errs[i])
+		}
+		if tokens[i] != tokens[0] {
+			t.Errorf("caller %d received a different token than caller 0", i)
+		}
+	}
+	if got := srv.lastForm().Get("requested_token_use"); got != "on_behalf_of" {
+		t.Errorf("requested_token_use = %q, want on_behalf_of", got)
+	}
+}
+
+// manualToken returns a token expiring in d.
+func manualToken(t *testing.T, d time.Duration) *adal.ServicePrincipalToken {
+	t.Helper()
+	oauthConfig, err := adal.NewOAuthConfig("https://login.microsoftonline.com/", "tenant")
+	if err != nil {
+		t.Fatal(err)
+	}
+	spt, err := adal.NewServicePrincipalTokenFromManualToken(*oauthConfig, "client", "resource", testToken("resource", d))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return spt
+}
+
+func TestOBOTokenCache(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		expiry time.Duration
+		want   bool
+	}{
+		{name: "valid", expiry: time.Hour, want: true},
+		{name: "expired", expiry: -time.Hour, want: false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := newTestConfig(t)
+			spt := manualToken(t, tc.expiry)
+			if got := c.storeOBOToken("key", spt); got != spt {
+				t.Fatal("storeOBOToken didn't cache the token of an empty key")
+			}
+			if _, ok := c.oboToken("key"); ok != tc.want {
+				t.Errorf("oboToken() cached = %v, want %v", ok, tc.want)
+			}
+			c.oboMu.Lock()
+			_, stored := c.oboTokens["key"]
+			c.oboMu.Unlock()
+			if stored != tc.want {
+				t.Errorf("token stored after lookup = %v, want %v", stored, tc.want)
+			}
+		})
+	}
+
+	t.Run("keeps the token stored first", func(t *testing.T) {
+		c := newTestConfig(t)
+		first, second := manualToken(t, time.Hour), manualToken(t, time.Hour)
+		c.storeOBOToken("key", first)
+		if got := c.storeOBOToken("key", second); got != first {
+			t.Error("storeOBOToken replaced an unexpired token")
+		}
+	})
+}
@@ -0,0 +1,29 @@
+package azauth
+
+import (
+	"github.com/Azure/go-autorest/autorest/adal"
+	"github.com/Azure/go-autorest/logger"
+)
+
+// WithUsernamePassword selects the resource owner password credentials (ROPC) flow as the credential source.
+//
+// WARNING: ROPC sends the user's password to AAD directly and is incompatible with MFA, conditional access,
+// and federated or personal accounts. It exists for legacy integration tests only; prefer WithDeviceCode or
+// WithInteractiveBrowser for users and a service principal or managed identity for everything else.
+func WithUsernamePassword(username, password string) Option {
+	return func(c *Config) {
+		c.credential = func(c *Config, resource string) (*adal.ServicePrincipalToken, error) {
+			return c.passwordToken(username, password, resource)
+		}
+	}
+}
+
+// passwordToken authenticates username with password as the Config's client ID.
+func (c *Config) passwordToken(username, password, resource string) (*adal.ServicePrincipalToken, error) {
+	logger.Instance.Writeln(logger.LogWarning, "azauth: using the resource owner password credentials flow, which is not recommended")
+	oauthConfig, err := c.oauthConfig()
+	if err != nil {
+		return nil, err
+	}
+	return adal.NewServicePrincipalTokenFromUsernamePassword(*oauthConfig, c.clientID(), username, password, resource)
+}
@@ -0,0 +1,271 @@
+package azauth
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/adal"
+	"github.com/Azure/go-autorest/logger"
+)
+
+// persistTimeout bounds reads and writes of the persistent cache, since keychain access may hang when no
+// keyring daemon is running.
+const persistTimeout = 5 * time.Second
+
+// lockPollInterval is how often a process waiting on the persistent cache's lock retries it.
+const lockPollInterval = 50 * time.Millisecond
+
+// WithPersistentTokenCache persists access tokens to path, encrypted with DPAPI on Windows and with a key kept
+// in the Keychain on macOS or the Secret Service on Linux, so short-lived processes reuse an unexpired token
+// instead of signing in or calling IMDS on every run. Refresh tokens are never persisted. The cache is best
+// effort: when the file or the key can't be read or written, tokens are acquired as usual.
+func WithPersistentTokenCache(path string) Option {
+	return func(c *Config) {
+		c.persist = &persistentCache{path: path, encrypt: true}
+	}
+}
+
+// WithSharedTokenFile shares access tokens with other processes on the host through the file at path, so
+// replicas of a service running as the same user reuse each other's tokens rather than each acquiring its own.
+// Acquisitions are serialized across processes with an advisory lock on path.lock, so when a token is due
+// one process acquires it and the others pick it up from the file. Unlike WithPersistentTokenCache, tokens
+// are stored unencrypted, protected only by the file's owner-only permissions, so hosts without a keyring
+// can share them. Refresh tokens are never written to the file.
+func WithSharedTokenFile(path string) Option {
+	return func(c *Config) {
+		c.persist = &persistentCache{path: path}
+	}
+}
+
+// persistentCache is a file of tokens keyed by identity and resource, optionally encrypted.
+// Processes sharing the file serialize acquisitions through an advisory lock.
+type persistentCache struct {
+	path    string
+	encrypt bool
+	mu      sync.Mutex
+}
+
+// load returns the tokens in the cache. A missing file is an empty cache.
+func (p *persistentCache) load(ctx context.Context) (map[string]adal.Token, error) {
+	tokens := map[string]adal.Token{}
+	b, err := ioutil.ReadFile(p.path)
+	if os.IsNotExist(err) {
+		return tokens, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if p.encrypt {
+		if b, err = unprotectData(ctx, b); err != nil {
+			return nil, err
+		}
+	}
+	if err := json.Unmarshal(b, &tokens); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// get returns the token cached under key, if any.
+func (p *persistentCache) get(ctx context.Context, key string) (adal.Token, bool) {
+	ctx, cancel := context.WithTimeout(ctx, persistTimeout)
+	defer cancel()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	tokens, err := p.load(ctx)
+	if err != nil {
+		logger.Instance.Writef(logger.LogWarning, "azauth: failed to read the persistent token cache: %v\n", err)
+		return adal.Token{}, false
+	}
+	token, ok := tokens[key]
+	return token, ok
+}
+
+// putLocked caches token under key, dropping expired tokens. The caller must hold the file lock, so the
+// load, update, and save can't interleave with another process's and drop its tokens.
+func (p *persistentCache) putLocked(ctx context.Context, key string, token adal.Token) error {
+	ctx, cancel := context.WithTimeout(ctx, persistTimeout)
+	defer cancel()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	tokens, err := p.load(ctx)
+	if err != nil {
+		// an unreadable cache, e.g. after the key was rotated, is replaced.
+		tokens = map[string]adal.Token{}
+	}
+	for k, t := range tokens {
+		if t.IsExpired() {
+			delete(tokens, k)
+		}
+	}
+	tokens[key] = token
+	return p.save(ctx, tokens)
+}
+
+// remove drops the tokens whose keys match from the cache.
+func (p *persistentCache) remove(ctx context.Context, match func(key string) bool) error {
+	ctx, cancel := context.WithTimeout(ctx, persistTimeout)
+	defer cancel()
+	unlock, err := p.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	tokens, err := p.load(ctx)
+	if err != nil {
+		return err
+	}
+	for k := range tokens {
+		if match(k) {
+			delete(tokens, k)
+		}
+	}
+	return p.save(ctx, tokens)
+}
+
+// save replaces the file with tokens atomically. The caller must hold the file lock.
+func (p *persistentCache) save(ctx context.Context, tokens map[string]adal.Token) error {
+	b, err := json.Marshal(tokens)
+	if err != nil {
+		return err
+	}
+	if p.encrypt {
+		if b, err = protectData(ctx, b); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(p.path), 0700); err != nil {
+		return err
+	}
+	// the temporary file is unique, so concurrent writers never interleave.
+	f, err := ioutil.TempFile(filepath.Dir(p.path), filepath.Base(p.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), p.path); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
+
+// persistKey identifies the tokens of the configured identity for resource. Tokens of a source other than
+// the configured one, such as those of GetAuthorizerFromMSI, are told apart by source.
+func (c *Config) persistKey(source CredentialSource, resource string) string {
+	parts := []string{c.tenantID(), c.clientID(), c.msiClientID, c.msiResourceID, c.msiObjectID}
+	if source != "" {
+		parts = append(parts, string(source))
+	}
+	return strings.Join(append(parts, resource), "|")
+}
+
+// lock takes the advisory lock shared by the processes using the cache, polling until it is free or ctx is done.
+func (p *persistentCache) lock(ctx context.Context) (func(), error) {
+	return lockFile(ctx, p.path+".lock")
+}
+
+// lockFile takes an exclusive advisory lock on the file at path, creating it if needed, polling until the lock
+// is free or ctx is done. It returns the function releasing the lock.
+func lockFile(ctx context.Context, path string) (func(), error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	ticker := time.NewTicker(lockPollInterval)
+	defer ticker.Stop()
+	for {
+		locked, err := tryLockFile(f)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if locked {
+			return func() {
+				unlockFile(f)
+				f.Close()
+			}, nil
+		}
+		select {
+		case <-ctx.Done():
+			f.Close()
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// persisted wraps cred, the credential of source, so tokens are served from the persistent cache while they are fresh, and every token
+// it acquires is written back. Refreshes hold the cache's lock, so processes sharing it acquire each token once.
+func (c *Config) persisted(source CredentialSource, cred credential) credential {
+	if c.persist == nil {
+		return cred
+	}
+	return func(c *Config, resource string) (*adal.ServicePrincipalToken, error) {
+		inner, err := cred(c, resource)
+		if err != nil {
+			return nil, err
+		}
+		key := c.persistKey(source, resource)
+		return newCustomToken(url.URL{Scheme: "file", Path: c.persist.path}, resource, func(ctx context.Context, resource string) (*adal.Token, error) {
+			// tokens answering a claims challenge must be acquired anew.
+			claims, _ := ctx.Value(claimsKey{}).(string)
+			fresh := func() (*adal.Token, bool) {
+				if claims != "" {
+					return nil, false
+				}
+				token, ok := c.persist.get(ctx, key)
+				return &token, ok && !token.WillExpireIn(c.dueWithin(ctx))
+			}
+			// the file is replaced atomically, so a fresh token written by another process is served unlocked.
+			if token, ok := fresh(); ok {
+				return token, nil
+			}
+			unlock, err := c.persist.lock(ctx)
+			locked := err == nil
+			if locked {
+				defer unlock()
+			} else {
+				logger.Instance.Writef(logger.LogWarning, "azauth: failed to lock the persistent token cache: %v\n", err)
+			}
+			if token, ok := fresh(); ok {
+				return token, nil
+			}
+			if err := inner.RefreshWithContext(ctx); err != nil {
+				return nil, err
+			}
+			token := inner.Token()
+			if !locked {
+				// writing without the lock could drop tokens other processes are writing.
+				return &token, nil
+			}
+			// refresh tokens outlive access tokens by months, so they stay in memory.
+			persisted := token
+			persisted.RefreshToken = ""
+			if err := c.persist.putLocked(ctx, key, persisted); err != nil {
+				logger.Instance.Writef(logger.LogWarning, "azauth: failed to write the persistent token cache: %v\n", err)
+			}
+			return &token, nil
+		})
+	}
+}
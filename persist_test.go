@@ -0,0 +1,224 @@
+package azauth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/adal"
+)
+
+// testToken returns a token for resource expiring in d.
+func testToken(resource string, d time.Duration) adal.Token {
+	return adal.Token{
+		AccessToken: "token-" + resource,
+		ExpiresOn:   json.Number(strconv.FormatInt(time.Now().Add(d).Unix(), 10)),
+		Resource:    resource,
+		Type:        "Bearer",
+	}
+}
+
+func TestPersistentCacheConcurrentWriters(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	ctx := context.Background()
+	const writers = 16
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// each writer opens the file on its own, as separate processes do.
+			p := &persistentCache{path: path}
+			unlock, err := p.lock(ctx)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer unlock()
+			key := fmt.Sprintf("key%d", i)
+			if err := p.putLocked(ctx, key, testToken(key, time.Hour)); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	tokens, err := (&persistentCache{path: path}).load(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != writers {
+		t.Errorf("cache holds %d tokens, want %d", len(tokens), writers)
+	}
+	matches, _ := filepath.Glob(path + ".*.tmp")
+	if len(matches) != 0 {
+		t.Errorf("temporary files were left behind: %v", matches)
+	}
+}
+
+func TestPersistentCacheRemove(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	ctx := context.Background()
+	p := &persistentCache{path: path}
+	unlock, err := p.lock(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a|1", "a|2", "b|1"} {
+		if err := p.putLocked(ctx, key, testToken(key, time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// expired tokens are dropped on write.
+	if err := p.putLocked(ctx, "c|1", testToken("c", -time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.putLocked(ctx, "d|1", testToken("d", time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	unlock()
+
+	if err := p.remove(ctx, func(key string) bool { return key[0] == 'a' }); err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := p.load(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]bool{"a|1": false, "a|2": false, "b|1": true, "c|1": false, "d|1": true} {
+		if _, ok := tokens[key]; ok != want {
+			t.Errorf("token %s cached: %t, want %t", key, ok, want)
+		}
+	}
+}
+
+func TestLockFileWaitsForHolder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.json.lock")
+	unlock, err := lockFile(context.Background(), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*lockPollInterval)
+	defer cancel()
+	if _, err := lockFile(ctx, path); err != context.DeadlineExceeded {
+		t.Fatalf("second lock returned %v while the first was held, want %v", err, context.DeadlineExceeded)
+	}
+	unlock()
+	again, err := lockFile(context.Background(), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again()
+}
+
+func TestSharedTokenFileAcquiresOnce(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	var acquisitions atomic.Int32
+	refresh := func(ctx context.Context, resource string) (string, time.Time, error) {
+		acquisitions.Add(1)
+		time.Sleep(20 * time.Millisecond)
+		return "shared", time.Now().Add(time.Hour), nil
+	}
+
+	// Configs sharing the file stand in for replicas of a service.
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		c := newTestConfig(t, WithAccessToken("", time.Time{}, refresh), WithSharedTokenFile(path))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			spt, err := c.GetServicePrincipalTokenForResource("https://resource.example.com")
+			if err == nil {
+				err = spt.EnsureFresh()
+			}
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if got := spt.OAuthToken(); got != "shared" {
+				t.Errorf("token %q, want shared", got)
+			}
+		}()
+	}
+	wg.Wait()
+	if got := acquisitions.Load(); got != 1 {
+		t.Errorf("token acquired %d times, want 1", got)
+	}
+}
+
+func TestSharedTokenFileOmitsRefreshTokens(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	c := newTestConfig(t, WithSharedTokenFile(path))
+	c.credential = func(c *Config, resource string) (*adal.ServicePrincipalToken, error) {
+		return newCustomToken(url.URL{Scheme: "test"}, resource, func(ctx context.Context, resource string) (*adal.Token, error) {
+			token := testToken(resource, time.Hour)
+			token.RefreshToken = "refresh-token"
+			return &token, nil
+		})
+	}
+	spt, err := c.cachedToken("https://resource.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := spt.EnsureFresh(); err != nil {
+		t.Fatal(err)
+	}
+
+	tokens, err := c.persist.load(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, ok := tokens[c.persistKey("", "https://resource.example.com")]
+	if !ok {
+		t.Fatal("the token wasn't written to the file")
+	}
+	if token.AccessToken != "token-https://resource.example.com" || token.RefreshToken != "" {
+		t.Errorf("persisted token = %q with refresh token %q, want the access token alone", token.AccessToken, token.RefreshToken)
+	}
+}
+
+func TestSharedTokenFileServesFreshTokensUnlocked(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	c := newTestConfig(t, WithSharedTokenFile(path))
+	c.credential = func(c *Config, resource string) (*adal.ServicePrincipalToken, error) {
+		return newCustomToken(url.URL{Scheme: "test"}, resource, func(ctx context.Context, resource string) (*adal.Token, error) {
+			t.Error("a token was acquired while the file held a fresh one")
+			token := testToken(resource, time.Hour)
+			return &token, nil
+		})
+	}
+	const resource = "https://resource.example.com"
+	ctx := context.Background()
+	// another process holds the lock while it writes a token.
+	unlock, err := c.persist.lock(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unlock()
+	if err := c.persist.putLocked(ctx, c.persistKey("", resource), testToken(resource, time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	spt, err := c.cachedToken(resource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*lockPollInterval)
+	defer cancel()
+	if err := spt.EnsureFreshWithContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if ctx.Err() != nil {
+		t.Error("the token was served only after waiting on the lock")
+	}
+	if got := spt.OAuthToken(); got != "token-"+resource {
+		t.Errorf("token %q, want the one in the file", got)
+	}
+}
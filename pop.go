@@ -0,0 +1,144 @@
+package azauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/adal"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// popKeySize is the size of the RSA key proof-of-possession tokens are bound to.
+const popKeySize = 2048
+
+// GetPoPAuthorizerForResource fetches an authorizer that presents proof-of-possession (PoP) tokens for resource.
+// Tokens are bound to a key held by the Config, and every request is signed with a SignedHttpRequest
+// covering its method, host, and path, sent as "Authorization: PoP <signed request>".
+// The application authenticates with its client certificate or client secret.
+func (c *Config) GetPoPAuthorizerForResource(resource string) (autorest.Authorizer, error) {
+	key, err := c.popSigningKey()
+	if err != nil {
+		return nil, err
+	}
+	if c.clientID() == "" {
+		return nil, errors.New("a client ID must be provided for proof-of-possession tokens")
+	}
+	tokenEndpoint, err := url.Parse(fmt.Sprintf("%s%s/oauth2/v2.0/token", c.env.ActiveDirectoryEndpoint, c.tenantID()))
+	if err != nil {
+		return nil, err
+	}
+	oauthConfig := adal.OAuthConfig{TokenEndpoint: *tokenEndpoint}
+	reqCnf := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf(`{"kid":%q}`, key.kid)))
+
+	spt, err := c.tokenFrom(func(c *Config, resource string) (*adal.ServicePrincipalToken, error) {
+		return newCustomToken(*tokenEndpoint, resource, func(ctx context.Context, resource string) (*adal.Token, error) {
+			v := url.Values{}
+			v.Set("grant_type", "client_credentials")
+			v.Set("client_id", c.clientID())
+			v.Set("resource", resource)
+			v.Set("token_type", "pop")
+			v.Set("req_cnf", reqCnf)
+			if err := c.setClientCredentials(v, *tokenEndpoint); err != nil {
+				return nil, err
+			}
+			return c.requestToken(ctx, oauthConfig, v)
+		})
+	}, resource)
+	if err != nil {
+		return nil, err
+	}
+	return &popAuthorizer{spt: spt, key: key}, nil
+}
+
+// AuthorizeClientWithPoP tries to fetch an authorizer using GetPoPAuthorizerForResource and inject it into a client.
+func (c *Config) AuthorizeClientWithPoP(client *autorest.Client, resource string) error {
+	authorizer, err := c.GetPoPAuthorizerForResource(resource)
+	if err != nil {
+		return err
+	}
+	return c.inject(client, authorizer)
+}
+
+// popKey is the key PoP tokens are bound to, with its JWK and RFC 7638 thumbprint.
+type popKey struct {
+	private *rsa.PrivateKey
+	jwk     map[string]string
+	kid     string
+}
+
+// popSigningKey returns the Config's PoP key, generating it on first use.
+func (c *Config) popSigningKey() (*popKey, error) {
+	c.popOnce.Do(func() {
+		private, err := rsa.GenerateKey(rand.Reader, popKeySize)
+		if err != nil {
+			c.popErr = err
+			return
+		}
+		e := base64.RawURLEncoding.EncodeToString(big.NewInt(int64(private.E)).Bytes())
+		n := base64.RawURLEncoding.EncodeToString(private.N.Bytes())
+		// the thumbprint is the hash of the required members in lexicographic order.
+		sum := sha256.Sum256([]byte(fmt.Sprintf(`{"e":%q,"kty":"RSA","n":%q}`, e, n)))
+		c.pop = &popKey{
+			private: private,
+			jwk:     map[string]string{"kty": "RSA", "e": e, "n": n},
+			kid:     base64.RawURLEncoding.EncodeToString(sum[:]),
+		}
+	})
+	return c.pop, c.popErr
+}
+
+// popAuthorizer signs each request with a PoP token bound to key.
+type popAuthorizer struct {
+	spt *adal.ServicePrincipalToken
+	key *popKey
+}
+
+// WithAuthorization implements autorest.Authorizer.
+func (a *popAuthorizer) WithAuthorization() autorest.PrepareDecorator {
+	return func(p autorest.Preparer) autorest.Preparer {
+		return autorest.PreparerFunc(func(r *http.Request) (*http.Request, error) {
+			r, err := p.Prepare(r)
+			if err != nil {
+				return r, err
+			}
+			if err := a.spt.EnsureFreshWithContext(r.Context()); err != nil {
+				return r, err
+			}
+			shr, err := a.sign(r)
+			if err != nil {
+				return r, err
+			}
+			return autorest.Prepare(r, autorest.WithHeader("Authorization", "PoP "+shr))
+		})
+	}
+}
+
+// sign returns a SignedHttpRequest binding the current token to r's method, host, and path.
+func (a *popAuthorizer) sign(r *http.Request) (string, error) {
+	nonce, err := randomString(16)
+	if err != nil {
+		return "", err
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"at":    a.spt.OAuthToken(),
+		"ts":    time.Now().Unix(),
+		"m":     r.Method,
+		"u":     r.URL.Host,
+		"p":     r.URL.EscapedPath(),
+		"nonce": nonce,
+		"cnf":   map[string]interface{}{"jwk": a.key.jwk},
+	})
+	token.Header["typ"] = "pop"
+	token.Header["kid"] = a.key.kid
+	return token.SignedString(a.key.private)
+}
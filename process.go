@@ -0,0 +1,99 @@
+package azauth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/adal"
+)
+
+// processOutput is the JSON an external credential process prints to stdout. It provides either a token
+// (AccessToken with ExpiresOn) or client credentials (ClientID, TenantID, and ClientSecret) to exchange.
+type processOutput struct {
+	AccessToken  string    `json:"accessToken"`
+	ExpiresOn    time.Time `json:"expiresOn"`
+	ClientID     string    `json:"clientId"`
+	TenantID     string    `json:"tenantId"`
+	ClientSecret string    `json:"clientSecret"`
+}
+
+// WithCredentialProcess selects an external command as the credential source, in the spirit of AWS's
+// credential_process. The command is run with the resource appended as its final argument and must print
+// a JSON object to stdout with either a token:
+//
+//	{"accessToken": "...", "expiresOn": "2006-01-02T15:04:05Z"}
+//
+// or client credentials which azauth exchanges for a token on the configured authority:
+//
+//	{"clientId": "...", "tenantId": "...", "clientSecret": "..."}
+//
+// expiresOn may be omitted when the token is a JWT, whose exp claim is used instead. The command is run
+// again on every refresh.
+func WithCredentialProcess(command string, args ...string) Option {
+	return func(c *Config) {
+		c.credential = func(c *Config, resource string) (*adal.ServicePrincipalToken, error) {
+			return newCustomToken(url.URL{Scheme: "process", Opaque: command}, resource, func(ctx context.Context, resource string) (*adal.Token, error) {
+				out, err := runCredentialProcess(ctx, command, append(append([]string{}, args...), resource)...)
+				if err != nil {
+					return nil, err
+				}
+				if out.AccessToken != "" {
+					expiresOn := out.ExpiresOn
+					if expiresOn.IsZero() {
+						expiresOn = jwtExpiry(out.AccessToken)
+					}
+					if expiresOn.IsZero() {
+						// without an expiry the token would be considered expired, and the command run on every request.
+						return nil, errors.New("credential process output must contain expiresOn for a token that isn't a JWT")
+					}
+					return &adal.Token{
+						AccessToken: out.AccessToken,
+						ExpiresOn:   json.Number(strconv.FormatInt(expiresOn.Unix(), 10)),
+						Resource:    resource,
+						Type:        "Bearer",
+					}, nil
+				}
+				if out.ClientID == "" || out.TenantID == "" || out.ClientSecret == "" {
+					return nil, errors.New("credential process output must contain accessToken or clientId, tenantId, and clientSecret")
+				}
+				oauthConfig, err := c.tenantOAuthConfig(out.TenantID)
+				if err != nil {
+					return nil, err
+				}
+				v := url.Values{}
+				v.Set("grant_type", "client_credentials")
+				v.Set("client_id", out.ClientID)
+				v.Set("client_secret", out.ClientSecret)
+				v.Set("resource", resource)
+				return c.requestToken(ctx, *oauthConfig, v)
+			})
+		}
+	}
+}
+
+// runCredentialProcess runs command and decodes its stdout.
+func runCredentialProcess(ctx context.Context, command string, args ...string) (*processOutput, error) {
+	ctx, cancel := context.WithTimeout(ctx, developerToolTimeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, command, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("credential process %s failed: %v: %s", command, err, strings.TrimSpace(stderr.String()))
+	}
+	var out processOutput
+	if err := json.Unmarshal(stdout.Bytes(), &out); err != nil {
+		return nil, fmt.Errorf("failed to parse credential process output: %v", err)
+	}
+	return &out, nil
+}
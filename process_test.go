@@ -0,0 +1,96 @@
+//go:build !windows
+
+package azauth
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// credentialProcess returns a credential process printing output.
+func credentialProcess(t *testing.T, output string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "credential-process")
+	if err := ioutil.WriteFile(path, []byte("#!/bin/sh\ncat <<'EOF'\n"+output+"\nEOF\n"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestCredentialProcess(t *testing.T) {
+	expiresOn := time.Now().Add(time.Hour).Truncate(time.Second)
+	jwtToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"exp": expiresOn.Unix()}).SignedString([]byte("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name       string
+		output     string
+		adfs       bool
+		wantToken  string
+		wantPath   string
+		wantErr    bool
+		wantExpiry time.Time
+	}{
+		{
+			name:       "token",
+			output:     fmt.Sprintf(`{"accessToken":"opaque","expiresOn":%q}`, expiresOn.Format(time.RFC3339)),
+			wantToken:  "opaque",
+			wantExpiry: expiresOn,
+		},
+		{name: "JWT without expiry", output: fmt.Sprintf(`{"accessToken":%q}`, jwtToken), wantToken: jwtToken, wantExpiry: expiresOn},
+		{name: "opaque token without expiry", output: `{"accessToken":"opaque"}`, wantErr: true},
+		{
+			name:       "client credentials",
+			output:     `{"clientId":"client","tenantId":"process-tenant","clientSecret":"secret"}`,
+			wantToken:  "token",
+			wantPath:   "/process-tenant/oauth2/token",
+			wantExpiry: expiresOn,
+		},
+		{
+			name:       "client credentials on ADFS",
+			output:     `{"clientId":"client","tenantId":"process-tenant","clientSecret":"secret"}`,
+			adfs:       true,
+			wantToken:  "token",
+			wantPath:   "/adfs/oauth2/token",
+			wantExpiry: expiresOn,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			aad := newAADServer(t)
+			opts := append(aad.options(), WithCredentialProcess(credentialProcess(t, tc.output)))
+			if tc.adfs {
+				opts = append(opts, WithADFS())
+			}
+			c := newTestConfig(t, opts...)
+			spt, err := c.cachedToken("https://resource.example.com")
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = spt.Refresh()
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("Refresh() succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := spt.OAuthToken(); got != tc.wantToken {
+				t.Errorf("token %q, want %q", got, tc.wantToken)
+			}
+			if got := aad.lastPath(); got != tc.wantPath {
+				t.Errorf("token requested from %q, want %q", got, tc.wantPath)
+			}
+			if got := spt.Token().Expires(); got.Sub(tc.wantExpiry).Abs() > time.Minute {
+				t.Errorf("token expires at %s, want %s", got, tc.wantExpiry)
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+//go:build !windows
+
+package azauth
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io"
+)
+
+// protectAccount names the keychain entry holding the key that encrypts persisted tokens.
+const protectAccount = "token-cache-key"
+
+// protectData encrypts b with AES-GCM under a key kept in the OS keychain, generating the key on first use.
+func protectData(ctx context.Context, b []byte) ([]byte, error) {
+	aead, err := protectionKey(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, b, nil), nil
+}
+
+// unprotectData decrypts b, which was encrypted by protectData.
+func unprotectData(ctx context.Context, b []byte) ([]byte, error) {
+	aead, err := protectionKey(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < aead.NonceSize() {
+		return nil, errors.New("protected data is truncated")
+	}
+	return aead.Open(nil, b[:aead.NonceSize()], b[aead.NonceSize():], nil)
+}
+
+// protectionKey returns an AEAD for the key in the OS keychain, creating the key if it is missing and create is set.
+// Failures to read the keychain are returned rather than replacing the key, which would make every token encrypted
+// under it unreadable.
+func protectionKey(ctx context.Context, create bool) (cipher.AEAD, error) {
+	encoded, err := readKeychain(ctx, keychainService, protectAccount)
+	if err != nil && !errors.Is(err, errNotInKeychain) {
+		return nil, err
+	}
+	var key []byte
+	if err == nil {
+		key, err = base64.StdEncoding.DecodeString(encoded)
+	}
+	// a malformed key can't have encrypted anything, so it is replaced like a missing one.
+	if err != nil || len(key) != 32 {
+		if !create {
+			return nil, errors.New("the token cache key is not in the keychain")
+		}
+		key = make([]byte, 32)
+		if _, err := io.ReadFull(rand.Reader, key); err != nil {
+			return nil, err
+		}
+		if err := writeKeychain(ctx, keychainService, protectAccount, base64.StdEncoding.EncodeToString(key)); err != nil {
+			return nil, err
+		}
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
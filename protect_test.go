@@ -0,0 +1,84 @@
+//go:build linux
+
+package azauth
+
+import (
+	"context"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fakeSecretTool puts a secret-tool on PATH that keeps its secret in a file, and returns the file's path.
+// Lookups fail with the message in FAKE_SECRET_TOOL_ERROR when it is set.
+func fakeSecretTool(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	script := `#!/bin/sh
+case "$1" in
+lookup)
+	if [ -n "$FAKE_SECRET_TOOL_ERROR" ]; then echo "$FAKE_SECRET_TOOL_ERROR" >&2; exit 1; fi
+	if [ -f "$FAKE_SECRET_TOOL_FILE" ]; then cat "$FAKE_SECRET_TOOL_FILE"; exit 0; fi
+	exit 1;;
+store)
+	cat > "$FAKE_SECRET_TOOL_FILE";;
+esac
+`
+	if err := ioutil.WriteFile(filepath.Join(dir, "secret-tool"), []byte(script), 0700); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "secret")
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("FAKE_SECRET_TOOL_FILE", file)
+	return file
+}
+
+func TestProtectionKey(t *testing.T) {
+	existing := base64.StdEncoding.EncodeToString(make([]byte, 32))
+	for _, tc := range []struct {
+		name    string
+		stored  string
+		failure string
+		create  bool
+		wantErr bool
+		// wantStored is the key left in the keychain, or empty for a newly generated one.
+		wantStored string
+	}{
+		{name: "existing key", stored: existing, create: true, wantStored: existing},
+		{name: "missing key", create: true},
+		{name: "missing key without create", wantErr: true},
+		{name: "malformed key", stored: "malformed", create: true},
+		{
+			name:       "keychain unavailable",
+			stored:     existing,
+			failure:    "Cannot autolaunch D-Bus without X11 $DISPLAY",
+			create:     true,
+			wantErr:    true,
+			wantStored: existing,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			file := fakeSecretTool(t)
+			if tc.stored != "" {
+				if err := ioutil.WriteFile(file, []byte(tc.stored), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			t.Setenv("FAKE_SECRET_TOOL_ERROR", tc.failure)
+
+			_, err := protectionKey(context.Background(), tc.create)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("protectionKey() = %v, want an error: %t", err, tc.wantErr)
+			}
+			stored, _ := ioutil.ReadFile(file)
+			switch {
+			case tc.wantStored != "" && string(stored) != tc.wantStored:
+				t.Errorf("keychain holds %q, want the key %q kept", stored, tc.wantStored)
+			case tc.wantStored == "" && !tc.wantErr && (len(stored) == 0 || string(stored) == tc.stored):
+				t.Errorf("keychain holds %q, want a new key", stored)
+			}
+		})
+	}
+}
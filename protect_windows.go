@@ -0,0 +1,56 @@
+package azauth
+
+import (
+	"context"
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	crypt32                = syscall.NewLazyDLL("crypt32.dll")
+	kernel32               = syscall.NewLazyDLL("kernel32.dll")
+	procCryptProtectData   = crypt32.NewProc("CryptProtectData")
+	procCryptUnprotectData = crypt32.NewProc("CryptUnprotectData")
+	procLocalFree          = kernel32.NewProc("LocalFree")
+)
+
+// dataBlob mirrors DATA_BLOB.
+type dataBlob struct {
+	cbData uint32
+	pbData *byte
+}
+
+func newDataBlob(b []byte) *dataBlob {
+	if len(b) == 0 {
+		return &dataBlob{}
+	}
+	return &dataBlob{cbData: uint32(len(b)), pbData: &b[0]}
+}
+
+// bytes copies the blob out of memory allocated by DPAPI and frees it.
+func (d *dataBlob) bytes() []byte {
+	if d.pbData == nil {
+		return nil
+	}
+	defer procLocalFree.Call(uintptr(unsafe.Pointer(d.pbData)))
+	return append([]byte(nil), unsafe.Slice(d.pbData, d.cbData)...)
+}
+
+// protectData encrypts b with DPAPI for the current user.
+func protectData(_ context.Context, b []byte) ([]byte, error) {
+	var out dataBlob
+	if ret, _, err := procCryptProtectData.Call(uintptr(unsafe.Pointer(newDataBlob(b))), 0, 0, 0, 0, 0, uintptr(unsafe.Pointer(&out))); ret == 0 {
+		return nil, fmt.Errorf("failed to protect data: %v", err)
+	}
+	return out.bytes(), nil
+}
+
+// unprotectData decrypts b, which was encrypted by protectData.
+func unprotectData(_ context.Context, b []byte) ([]byte, error) {
+	var out dataBlob
+	if ret, _, err := procCryptUnprotectData.Call(uintptr(unsafe.Pointer(newDataBlob(b))), 0, 0, 0, 0, 0, uintptr(unsafe.Pointer(&out))); ret == 0 {
+		return nil, fmt.Errorf("failed to unprotect data: %v", err)
+	}
+	return out.bytes(), nil
+}
@@ -0,0 +1,92 @@
+package azauth
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/adal"
+)
+
+// ThrottledError is returned when token acquisitions for a resource exceed the rate limit set with
+// WithTokenRateLimit and no unexpired token is cached.
+type ThrottledError struct {
+	// Resource is the resource the token was requested for.
+	Resource string
+	// RetryAfter is how long until an acquisition is allowed again.
+	RetryAfter time.Duration
+}
+
+// Error implements error.
+func (e *ThrottledError) Error() string {
+	return fmt.Sprintf("token acquisition for %s is rate limited, retry after %s", e.Resource, e.RetryAfter)
+}
+
+// WithTokenRateLimit limits token acquisitions for each resource and identity to burst at once, replenished
+// at one every interval, guarding the token service against retry loops that keep forcing refreshes. Limited
+// refreshes keep the current token while it is unexpired and fail with a *ThrottledError otherwise.
+func WithTokenRateLimit(interval time.Duration, burst int) Option {
+	return func(c *Config) {
+		c.rateInterval, c.rateBurst = interval, burst
+	}
+}
+
+// limiter is a token bucket allowing burst acquisitions, replenished at one every interval.
+type limiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	burst    float64
+	tokens   float64
+	last     time.Time
+}
+
+func newLimiter(interval time.Duration, burst int) *limiter {
+	return &limiter{interval: interval, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// allow takes a token from the bucket, or reports how long until one is available.
+func (l *limiter) allow() (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	l.tokens += float64(now.Sub(l.last)) / float64(l.interval)
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	if l.tokens >= 1 {
+		l.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - l.tokens) * float64(l.interval))
+}
+
+// limited wraps cred so its refreshes are rate limited, when a limit is configured.
+func (c *Config) limited(cred credential) credential {
+	if c.rateInterval <= 0 || c.rateBurst <= 0 {
+		return cred
+	}
+	return func(c *Config, resource string) (*adal.ServicePrincipalToken, error) {
+		inner, err := cred(c, resource)
+		if err != nil {
+			return nil, err
+		}
+		l := newLimiter(c.rateInterval, c.rateBurst)
+		return newCustomToken(url.URL{Scheme: "ratelimit"}, resource, func(ctx context.Context, resource string) (*adal.Token, error) {
+			if ok, retryAfter := l.allow(); !ok {
+				token := inner.Token()
+				if token.AccessToken != "" && !token.IsExpired() {
+					return &token, nil
+				}
+				return nil, &ThrottledError{Resource: resource, RetryAfter: retryAfter}
+			}
+			if err := inner.RefreshWithContext(ctx); err != nil {
+				return nil, err
+			}
+			token := inner.Token()
+			return &token, nil
+		})
+	}
+}
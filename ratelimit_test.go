@@ -0,0 +1,93 @@
+package azauth
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/adal"
+)
+
+func TestLimiter(t *testing.T) {
+	const interval = time.Hour
+	for _, tc := range []struct {
+		name  string
+		burst int
+		// taken is how many acquisitions were allowed elapsed ago, and after how many were allowed since.
+		taken, after int
+		elapsed      time.Duration
+		wantOK       bool
+		// the reported wait must fall within [wantMin, wantMax].
+		wantMin, wantMax time.Duration
+	}{
+		{name: "burst available", burst: 2, taken: 1, wantOK: true},
+		{name: "burst exhausted", burst: 2, taken: 2, wantMin: interval - time.Minute, wantMax: interval},
+		{name: "partly replenished", burst: 1, taken: 1, elapsed: interval / 4, wantMin: interval/2 + interval/4 - time.Minute, wantMax: interval / 4 * 3},
+		{name: "replenished", burst: 1, taken: 1, elapsed: interval, wantOK: true},
+		{name: "replenishment capped at burst", burst: 1, elapsed: 10 * interval, after: 1, wantMin: interval - time.Minute, wantMax: interval},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			l := newLimiter(interval, tc.burst)
+			for i := 0; i < tc.taken; i++ {
+				if ok, _ := l.allow(); !ok {
+					t.Fatalf("acquisition %d within the burst wasn't allowed", i+1)
+				}
+			}
+			l.last = l.last.Add(-tc.elapsed)
+			for i := 0; i < tc.after; i++ {
+				if ok, _ := l.allow(); !ok {
+					t.Fatalf("acquisition %d after replenishment wasn't allowed", i+1)
+				}
+			}
+			ok, wait := l.allow()
+			if ok != tc.wantOK {
+				t.Fatalf("allow() = %t, want %t", ok, tc.wantOK)
+			}
+			if wait < tc.wantMin || wait > tc.wantMax {
+				t.Errorf("allow() wait = %s, want between %s and %s", wait, tc.wantMin, tc.wantMax)
+			}
+		})
+	}
+}
+
+func TestLimited(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		// lifetime is how long the tokens issued by the token service are valid.
+		lifetime      time.Duration
+		wantErr       bool
+		wantRefreshes int
+	}{
+		{name: "keeps the unexpired token", lifetime: time.Hour, wantRefreshes: 1},
+		{name: "throttles without a token", lifetime: -time.Minute, wantErr: true, wantRefreshes: 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := newTestConfig(t, WithTokenRateLimit(time.Hour, 1))
+			refreshes := 0
+			cred := func(c *Config, resource string) (*adal.ServicePrincipalToken, error) {
+				return newCustomToken(url.URL{Scheme: "test"}, resource, func(ctx context.Context, resource string) (*adal.Token, error) {
+					refreshes++
+					token := testToken(resource, tc.lifetime)
+					return &token, nil
+				})
+			}
+			spt, err := c.limited(cred)(c, "https://resource.example.com")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := spt.Refresh(); err != nil {
+				t.Fatal(err)
+			}
+			err = spt.Refresh()
+			var throttled *ThrottledError
+			if got := errors.As(err, &throttled); got != tc.wantErr {
+				t.Errorf("second Refresh() = %v, want a *ThrottledError: %t", err, tc.wantErr)
+			}
+			if refreshes != tc.wantRefreshes {
+				t.Errorf("refreshes = %d, want %d", refreshes, tc.wantRefreshes)
+			}
+		})
+	}
+}
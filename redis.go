@@ -0,0 +1,135 @@
+package azauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/azure"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	// redisRetryDelay is the first delay before asking again for a Redis token when the token service returns
+	// the token already in use, doubling up to redisMaxRetryDelay.
+	redisRetryDelay    = 5 * time.Second
+	redisMaxRetryDelay = time.Minute
+)
+
+// redisAudiences maps clouds to the audience of Azure Cache for Redis, which environments don't carry.
+var redisAudiences = map[string]string{
+	azure.PublicCloud.Name:       "https://redis.azure.com",
+	azure.USGovernmentCloud.Name: "https://redis.azure.us",
+	azure.ChinaCloud.Name:        "https://redis.azure.cn",
+}
+
+// redisAudience returns the Azure Cache for Redis audience of env, or the empty string in other clouds.
+func redisAudience(env azure.Environment) string {
+	return redisAudiences[env.Name]
+}
+
+// RedisCredentials returns the AUTH credentials of the Config's identity for Azure Cache for Redis: the
+// object ID of the identity as the username and an access token as the password. Its signature matches
+// go-redis's CredentialsProviderContext, which calls it for every new connection:
+//
+//	client := redis.NewClient(&redis.Options{
+//		Addr:                       "mycache.redis.cache.windows.net:6380",
+//		TLSConfig:                  &tls.Config{},
+//		CredentialsProviderContext: config.RedisCredentials,
+//	})
+//
+// Connections stay authenticated only until the token expires, so long lived connections must
+// authenticate again with RedisReauthenticate.
+func (c *Config) RedisCredentials(ctx context.Context) (username, password string, err error) {
+	password, _, err = c.redisToken(ctx, false)
+	if err != nil {
+		return "", "", err
+	}
+	claims := jwt.MapClaims{}
+	if _, _, err := new(jwt.Parser).ParseUnverified(password, claims); err != nil {
+		return "", "", fmt.Errorf("failed to read the object ID from the Redis access token: %w", err)
+	}
+	username, _ = claims["oid"].(string)
+	if username == "" {
+		return "", "", errors.New("the Redis access token has no oid claim")
+	}
+	return username, password, nil
+}
+
+// RedisReauthenticate calls reauth with fresh RedisCredentials whenever the token is refreshed, ahead of its
+// expiry, until ctx is done or the Config is closed. reauth should send AUTH with the credentials on every
+// open connection, e.g. with a go-redis client's OnConnect hook tracking them. When the token service keeps
+// returning the token in use, it is asked again with backoff until that token expires. Failures of reauth
+// are returned, ending the loop.
+func (c *Config) RedisReauthenticate(ctx context.Context, reauth func(ctx context.Context, username, password string) error) error {
+	var last time.Time
+	retryDelay := redisRetryDelay
+	for {
+		_, expiresOn, err := c.redisToken(ctx, false)
+		if err == nil && !last.IsZero() && !expiresOn.After(last) {
+			// the cached token wasn't refreshed, e.g. because the timer fired early, so refresh it now.
+			_, expiresOn, err = c.redisToken(ctx, true)
+		}
+		if err != nil {
+			return err
+		}
+
+		var wait time.Duration
+		if expiresOn.After(last) {
+			if !last.IsZero() {
+				username, password, err := c.RedisCredentials(ctx)
+				if err != nil {
+					return err
+				}
+				if err := reauth(ctx, username, password); err != nil {
+					return err
+				}
+			}
+			last = expiresOn
+			retryDelay = redisRetryDelay
+			wait = time.Until(expiresOn.Add(-c.refreshWindow()))
+		} else {
+			if !time.Now().Before(last) {
+				return errors.New("the Redis access token was not refreshed before it expired")
+			}
+			wait = retryDelay
+			if retryDelay *= 2; retryDelay > redisMaxRetryDelay {
+				retryDelay = redisMaxRetryDelay
+			}
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-c.stop:
+			timer.Stop()
+			return errClosed
+		case <-timer.C:
+		}
+	}
+}
+
+// redisToken returns a fresh Azure Cache for Redis access token and its expiry, refreshing the token even
+// when the cached one is fresh if force is set.
+func (c *Config) redisToken(ctx context.Context, force bool) (string, time.Time, error) {
+	resource := redisAudience(*c.env)
+	if resource == "" {
+		return "", time.Time{}, fmt.Errorf("Azure Cache for Redis AAD authentication is not available in %s", c.env.Name)
+	}
+	spt, err := c.cachedToken(resource)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	refresh := spt.EnsureFreshWithContext
+	if force {
+		refresh = spt.RefreshWithContext
+	}
+	if err := refresh(ctx); err != nil {
+		return "", time.Time{}, err
+	}
+	token := spt.Token()
+	return token.AccessToken, token.Expires(), nil
+}
@@ -0,0 +1,101 @@
+package azauth
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// redisTestToken returns an unverified access token for the object ID oid.
+func redisTestToken(t *testing.T, oid string) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"oid": oid}).SignedString([]byte("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestRedisReauthenticate(t *testing.T) {
+	const window = time.Hour
+	for _, tc := range []struct {
+		name string
+		// repeats is how many refreshes return the first token again before a new one is issued.
+		repeats     int
+		wantRefresh int
+	}{
+		{name: "refreshes before expiry", wantRefresh: 2},
+		{name: "forces a refresh when the token service repeats the token", repeats: 1, wantRefresh: 3},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			first, second := redisTestToken(t, "first"), redisTestToken(t, "second")
+			firstExpiry := time.Now().Add(window + time.Second).Truncate(time.Second)
+			var mu sync.Mutex
+			refreshes := 0
+			refresh := func(ctx context.Context, resource string) (string, time.Time, error) {
+				mu.Lock()
+				defer mu.Unlock()
+				refreshes++
+				if refreshes <= 1+tc.repeats {
+					return first, firstExpiry, nil
+				}
+				return second, time.Now().Add(2 * window).Truncate(time.Second), nil
+			}
+			c := newTestConfig(t, WithAccessToken("", time.Time{}, refresh), WithRefreshWindow(window))
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			var gotUsername, gotPassword string
+			err := c.RedisReauthenticate(ctx, func(ctx context.Context, username, password string) error {
+				gotUsername, gotPassword = username, password
+				cancel()
+				return nil
+			})
+			if !errors.Is(err, context.Canceled) {
+				t.Fatalf("RedisReauthenticate() error = %v, want context.Canceled", err)
+			}
+			if gotUsername != "second" || gotPassword != second {
+				t.Errorf("reauthenticated as %q, want the refreshed token of second", gotUsername)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if refreshes != tc.wantRefresh {
+				t.Errorf("refreshes = %d, want %d", refreshes, tc.wantRefresh)
+			}
+		})
+	}
+}
+
+func TestRedisCredentials(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		token        string
+		wantUsername string
+		wantErr      bool
+	}{
+		{name: "object ID", token: redisTestToken(t, "oid"), wantUsername: "oid"},
+		{name: "no object ID", token: redisTestToken(t, ""), wantErr: true},
+		{name: "not a JWT", token: "opaque", wantErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := newTestConfig(t, WithAccessToken(tc.token, time.Now().Add(time.Hour), nil))
+			username, password, err := c.RedisCredentials(context.Background())
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("RedisCredentials() succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if username != tc.wantUsername || password != tc.token {
+				t.Errorf("RedisCredentials() = %q, %q, want %q and the token", username, password, tc.wantUsername)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package azauth
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/Azure/go-autorest/autorest/adal"
+)
+
+// RefreshTokenStore persists a refresh token across token renewals, since AAD rotates refresh tokens on use.
+type RefreshTokenStore interface {
+	// Load returns the most recently saved refresh token.
+	Load() (string, error)
+	// Save replaces the stored refresh token.
+	Save(refreshToken string) error
+}
+
+// WithRefreshToken selects a stored refresh token as the credential source, for public clients that
+// obtained one through an earlier interactive sign in. Every renewal reads the token from store and
+// saves the rotated token AAD returns, so the store always holds a usable token.
+func WithRefreshToken(store RefreshTokenStore) Option {
+	return func(c *Config) {
+		var mu sync.Mutex
+		c.credential = func(c *Config, resource string) (*adal.ServicePrincipalToken, error) {
+			clientID := c.clientID()
+			if clientID == "" {
+				return nil, errors.New("a client ID must be provided to redeem a refresh token")
+			}
+			oauthConfig, err := c.oauthConfig()
+			if err != nil {
+				return nil, err
+			}
+			return c.refreshTokenCredential(*oauthConfig, clientID, resource, &mu, store)
+		}
+	}
+}
+
+// refreshTokenCredential returns a token for resource renewed by redeeming the refresh token in store
+// for clientID, saving rotated refresh tokens back to store. mu serializes redemptions sharing store.
+func (c *Config) refreshTokenCredential(oauthConfig adal.OAuthConfig, clientID, resource string, mu *sync.Mutex, store RefreshTokenStore) (*adal.ServicePrincipalToken, error) {
+	return newCustomToken(oauthConfig.TokenEndpoint, resource, func(ctx context.Context, resource string) (*adal.Token, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		refreshToken, err := store.Load()
+		if err != nil {
+			return nil, err
+		}
+		if refreshToken == "" {
+			return nil, errors.New("no refresh token is stored")
+		}
+		token, err := c.requestToken(ctx, oauthConfig, url.Values{
+			"grant_type":    {"refresh_token"},
+			"client_id":     {clientID},
+			"refresh_token": {refreshToken},
+			"resource":      {resource},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if token.RefreshToken != "" && token.RefreshToken != refreshToken {
+			if err := store.Save(token.RefreshToken); err != nil {
+				return nil, err
+			}
+		}
+		return token, nil
+	})
+}
+
+// MemoryRefreshTokenStore keeps a refresh token in memory for the lifetime of the process.
+type MemoryRefreshTokenStore struct {
+	mu    sync.Mutex
+	token string
+}
+
+// NewMemoryRefreshTokenStore returns a store holding refreshToken.
+func NewMemoryRefreshTokenStore(refreshToken string) *MemoryRefreshTokenStore {
+	return &MemoryRefreshTokenStore{token: refreshToken}
+}
+
+// Load implements RefreshTokenStore.
+func (s *MemoryRefreshTokenStore) Load() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.token, nil
+}
+
+// Save implements RefreshTokenStore.
+func (s *MemoryRefreshTokenStore) Save(refreshToken string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.token = refreshToken
+	return nil
+}
+
+// FileRefreshTokenStore keeps a refresh token in a file readable only by the current user.
+type FileRefreshTokenStore struct {
+	Path string
+}
+
+// Load implements RefreshTokenStore.
+func (s FileRefreshTokenStore) Load() (string, error) {
+	b, err := ioutil.ReadFile(s.Path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// Save implements RefreshTokenStore.
+func (s FileRefreshTokenStore) Save(refreshToken string) error {
+	tmp := s.Path + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(refreshToken), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.Path)
+}
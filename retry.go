@@ -0,0 +1,128 @@
+package azauth
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/adal"
+	msalerrors "github.com/AzureAD/microsoft-authentication-library-for-go/apps/errors"
+)
+
+// RetryPolicy controls how failed token acquisitions are retried.
+type RetryPolicy struct {
+	// MaxRetries is how many times a failed acquisition is retried.
+	MaxRetries int
+	// MinDelay is the backoff before the first retry, doubled for every later retry.
+	MinDelay time.Duration
+	// MaxDelay caps the backoff, including delays requested by the token service through Retry-After.
+	MaxDelay time.Duration
+}
+
+// WithRetryPolicy retries token acquisitions that fail with network errors, throttling (429), or server
+// errors (5xx) according to policy. Retries wait for the Retry-After the token service asked for, and
+// otherwise back off exponentially with full jitter so throttled callers don't retry in lockstep.
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(c *Config) {
+		c.retryPolicy = &policy
+	}
+}
+
+// retried wraps cred so its failed refreshes are retried, when a retry policy is configured.
+func (c *Config) retried(cred credential) credential {
+	if c.retryPolicy == nil || c.retryPolicy.MaxRetries <= 0 {
+		return cred
+	}
+	policy := *c.retryPolicy
+	return func(c *Config, resource string) (*adal.ServicePrincipalToken, error) {
+		inner, err := cred(c, resource)
+		if err != nil {
+			return nil, err
+		}
+		return newCustomToken(url.URL{Scheme: "retry"}, resource, func(ctx context.Context, resource string) (*adal.Token, error) {
+			for attempt := 0; ; attempt++ {
+				err := inner.RefreshWithContext(ctx)
+				if err == nil {
+					token := inner.Token()
+					return &token, nil
+				}
+				delay, retry := policy.delay(attempt, err)
+				if !retry {
+					return nil, err
+				}
+				select {
+				case <-ctx.Done():
+					return nil, err
+				case <-time.After(delay):
+				}
+			}
+		})
+	}
+}
+
+// delay returns how long to wait before retrying after attempt failed with err, and whether to retry at all.
+func (p RetryPolicy) delay(attempt int, err error) (time.Duration, bool) {
+	if attempt >= p.MaxRetries {
+		return 0, false
+	}
+	resp := errorResponse(err)
+	if resp == nil {
+		var netErr net.Error
+		if !errors.As(err, &netErr) {
+			return 0, false
+		}
+	} else if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < http.StatusInternalServerError {
+		return 0, false
+	}
+
+	if after, ok := retryAfter(resp); ok {
+		if p.MaxDelay > 0 && after > p.MaxDelay {
+			after = p.MaxDelay
+		}
+		return after, true
+	}
+	backoff := p.MinDelay << uint(attempt)
+	if backoff <= 0 || (p.MaxDelay > 0 && backoff > p.MaxDelay) {
+		backoff = p.MaxDelay
+	}
+	if backoff <= 0 {
+		return 0, true
+	}
+	return time.Duration(rand.Int63n(int64(backoff))), true
+}
+
+// errorResponse returns the token service's response to a failed acquisition, if err carries one.
+func errorResponse(err error) *http.Response {
+	var refreshErr adal.TokenRefreshError
+	if errors.As(err, &refreshErr) {
+		return refreshErr.Response()
+	}
+	var callErr msalerrors.CallErr
+	if errors.As(err, &callErr) {
+		return callErr.Resp
+	}
+	return nil
+}
+
+// retryAfter returns the delay requested by the Retry-After header of resp, in seconds or as an HTTP date.
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	if resp == nil {
+		return 0, false
+	}
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return time.Until(date), true
+	}
+	return 0, false
+}
@@ -0,0 +1,179 @@
+package azauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/adal"
+)
+
+// statusError is a failed token refresh answered with status, implementing adal.TokenRefreshError.
+type statusError struct {
+	status     int
+	retryAfter string
+}
+
+var _ adal.TokenRefreshError = statusError{}
+
+func (e statusError) Error() string {
+	return fmt.Sprintf("token refresh failed with status %d", e.status)
+}
+
+func (e statusError) Response() *http.Response {
+	resp := &http.Response{StatusCode: e.status, Header: http.Header{}}
+	if e.retryAfter != "" {
+		resp.Header.Set("Retry-After", e.retryAfter)
+	}
+	return resp
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := RetryPolicy{MaxRetries: 3, MinDelay: time.Second, MaxDelay: 5 * time.Second}
+	for _, tc := range []struct {
+		name      string
+		policy    RetryPolicy
+		attempt   int
+		err       error
+		wantRetry bool
+		// the delay must fall within [wantMin, wantMax].
+		wantMin, wantMax time.Duration
+	}{
+		{name: "retries exhausted", policy: policy, attempt: 3, err: statusError{status: http.StatusServiceUnavailable}},
+		{name: "client error", policy: policy, err: statusError{status: http.StatusBadRequest}},
+		{name: "not a network error", policy: policy, err: errors.New("invalid configuration")},
+		{
+			name:      "retry after",
+			policy:    policy,
+			err:       statusError{status: http.StatusTooManyRequests, retryAfter: "2"},
+			wantRetry: true,
+			wantMin:   2 * time.Second,
+			wantMax:   2 * time.Second,
+		},
+		{
+			name:      "retry after capped",
+			policy:    policy,
+			err:       statusError{status: http.StatusServiceUnavailable, retryAfter: "60"},
+			wantRetry: true,
+			wantMin:   5 * time.Second,
+			wantMax:   5 * time.Second,
+		},
+		{
+			name:      "exponential backoff",
+			policy:    policy,
+			attempt:   2,
+			err:       statusError{status: http.StatusInternalServerError},
+			wantRetry: true,
+			wantMax:   4 * time.Second,
+		},
+		{
+			name:      "network error backoff capped",
+			policy:    RetryPolicy{MaxRetries: 100, MinDelay: time.Second, MaxDelay: 3 * time.Second},
+			attempt:   70,
+			err:       &net.DNSError{Err: "timeout", IsTimeout: true},
+			wantRetry: true,
+			wantMax:   3 * time.Second,
+		},
+		{
+			name:      "no delays",
+			policy:    RetryPolicy{MaxRetries: 1},
+			err:       statusError{status: http.StatusBadGateway},
+			wantRetry: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, retry := tc.policy.delay(tc.attempt, tc.err)
+			if retry != tc.wantRetry {
+				t.Fatalf("delay() retry = %t, want %t", retry, tc.wantRetry)
+			}
+			if got < tc.wantMin || got > tc.wantMax {
+				t.Errorf("delay() = %s, want between %s and %s", got, tc.wantMin, tc.wantMax)
+			}
+		})
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	for _, tc := range []struct {
+		name             string
+		value            string
+		wantOK           bool
+		wantMin, wantMax time.Duration
+	}{
+		{name: "missing"},
+		{name: "seconds", value: "30", wantOK: true, wantMin: 30 * time.Second, wantMax: 30 * time.Second},
+		{name: "zero", value: "0", wantOK: true},
+		{name: "negative", value: "-1"},
+		{
+			name:    "HTTP date",
+			value:   time.Now().Add(time.Minute).UTC().Format(http.TimeFormat),
+			wantOK:  true,
+			wantMin: 58 * time.Second,
+			wantMax: time.Minute,
+		},
+		{name: "invalid", value: "soon"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			resp := &http.Response{Header: http.Header{}}
+			if tc.value != "" {
+				resp.Header.Set("Retry-After", tc.value)
+			}
+			got, ok := retryAfter(resp)
+			if ok != tc.wantOK {
+				t.Fatalf("retryAfter() ok = %t, want %t", ok, tc.wantOK)
+			}
+			if got < tc.wantMin || got > tc.wantMax {
+				t.Errorf("retryAfter() = %s, want between %s and %s", got, tc.wantMin, tc.wantMax)
+			}
+		})
+	}
+
+	if _, ok := retryAfter(nil); ok {
+		t.Error("retryAfter(nil) reported a delay")
+	}
+}
+
+func TestRetried(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		failures     int
+		err          error
+		wantErr      bool
+		wantAttempts int
+	}{
+		{name: "throttled then issued", failures: 2, err: statusError{status: http.StatusTooManyRequests, retryAfter: "0"}, wantAttempts: 3},
+		{name: "retries exhausted", failures: 5, err: statusError{status: http.StatusServiceUnavailable, retryAfter: "0"}, wantErr: true, wantAttempts: 4},
+		{name: "not retried", failures: 1, err: statusError{status: http.StatusUnauthorized}, wantErr: true, wantAttempts: 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := newTestConfig(t, WithRetryPolicy(RetryPolicy{MaxRetries: 3}))
+			attempts := 0
+			cred := func(c *Config, resource string) (*adal.ServicePrincipalToken, error) {
+				return newCustomToken(url.URL{Scheme: "test"}, resource, func(ctx context.Context, resource string) (*adal.Token, error) {
+					attempts++
+					if attempts <= tc.failures {
+						return nil, tc.err
+					}
+					token := testToken(resource, time.Hour)
+					return &token, nil
+				})
+			}
+			spt, err := c.retried(cred)(c, "https://resource.example.com")
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = spt.Refresh()
+			if (err != nil) != tc.wantErr {
+				t.Errorf("Refresh() = %v, want an error: %t", err, tc.wantErr)
+			}
+			if attempts != tc.wantAttempts {
+				t.Errorf("attempts = %d, want %d", attempts, tc.wantAttempts)
+			}
+		})
+	}
+}
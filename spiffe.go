@@ -0,0 +1,40 @@
+package azauth
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/adal"
+	"github.com/spiffe/go-spiffe/v2/svid/jwtsvid"
+	"github.com/spiffe/go-spiffe/v2/workloadapi"
+)
+
+// spiffeTimeout bounds each JWT-SVID fetch from the Workload API.
+const spiffeTimeout = 10 * time.Second
+
+// WithSPIFFESource selects a SPIFFE JWT-SVID as the federated client assertion.
+// socketPath is the SPIFFE Workload API socket; when empty, SPIFFE_ENDPOINT_SOCKET is used.
+// A fresh SVID with an audience of api://AzureADTokenExchange is fetched on every refresh.
+func WithSPIFFESource(socketPath string) Option {
+	var opts []workloadapi.ClientOption
+	if socketPath != "" {
+		if !strings.Contains(socketPath, "://") {
+			socketPath = "unix://" + socketPath
+		}
+		opts = append(opts, workloadapi.WithAddr(socketPath))
+	}
+	return func(c *Config) {
+		c.credential = func(c *Config, resource string) (*adal.ServicePrincipalToken, error) {
+			return c.federatedToken(resource, func() (string, error) {
+				ctx, cancel := context.WithTimeout(context.Background(), spiffeTimeout)
+				defer cancel()
+				svid, err := workloadapi.FetchJWTSVID(ctx, jwtsvid.Params{Audience: federatedAudience}, opts...)
+				if err != nil {
+					return "", err
+				}
+				return svid.Marshal(), nil
+			})
+		}
+	}
+}
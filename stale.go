@@ -0,0 +1,110 @@
+package azauth
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/adal"
+	"github.com/Azure/go-autorest/logger"
+)
+
+const (
+	// staleRetryMin and staleRetryMax bound the backoff between background refresh attempts of stale tokens.
+	staleRetryMin = 5 * time.Second
+	staleRetryMax = time.Minute
+)
+
+// WithStaleTokens keeps serving a token whose refresh failed, e.g. during an AAD outage, for up to
+// maxStaleness past the point its refresh was due and never past its expiry. Refresh is retried in the
+// background meanwhile, so requests don't fail or wait on an unavailable token service.
+func WithStaleTokens(maxStaleness time.Duration) Option {
+	return func(c *Config) {
+		c.maxStaleness = maxStaleness
+	}
+}
+
+// bearer wraps spt in a bearer authorizer, tolerating failed refreshes when stale tokens are allowed.
+func (c *Config) bearer(spt *adal.ServicePrincipalToken) autorest.Authorizer {
+	if c.maxStaleness <= 0 {
+		return autorest.NewBearerAuthorizer(spt)
+	}
+	return autorest.NewBearerAuthorizer(&staleToken{spt: spt, window: c.refreshWindow(), maxStaleness: c.maxStaleness, stop: c.stop})
+}
+
+// staleToken serves spt's current token when refreshing it fails while it is still usable.
+type staleToken struct {
+	spt          *adal.ServicePrincipalToken
+	window       time.Duration
+	maxStaleness time.Duration
+	stop         <-chan struct{}
+	retrying     int32
+}
+
+// OAuthToken implements adal.OAuthTokenProvider.
+func (s *staleToken) OAuthToken() string {
+	return s.spt.OAuthToken()
+}
+
+// EnsureFreshWithContext implements adal.RefresherWithContext.
+func (s *staleToken) EnsureFreshWithContext(ctx context.Context) error {
+	// while a background retry is pending, requests are served without waiting on another attempt.
+	if atomic.LoadInt32(&s.retrying) == 1 && s.usable() {
+		return nil
+	}
+	err := s.spt.EnsureFreshWithContext(ctx)
+	if err == nil || !s.usable() {
+		return err
+	}
+	logger.Instance.Writef(logger.LogWarning, "azauth: token refresh failed, serving the current token until it can be refreshed: %v\n", err)
+	s.retryInBackground()
+	return nil
+}
+
+// RefreshWithContext implements adal.RefresherWithContext.
+func (s *staleToken) RefreshWithContext(ctx context.Context) error {
+	return s.spt.RefreshWithContext(ctx)
+}
+
+// RefreshExchangeWithContext implements adal.RefresherWithContext.
+func (s *staleToken) RefreshExchangeWithContext(ctx context.Context, resource string) error {
+	return s.spt.RefreshExchangeWithContext(ctx, resource)
+}
+
+// usable reports whether the current token is unexpired and within the allowed staleness.
+func (s *staleToken) usable() bool {
+	token := s.spt.Token()
+	if token.AccessToken == "" {
+		return false
+	}
+	expires, now := token.Expires(), time.Now()
+	return now.Before(expires) && now.Sub(expires.Add(-s.window)) <= s.maxStaleness
+}
+
+// retryInBackground retries the refresh with backoff until it succeeds, the token is no longer usable,
+// or the Config is closed.
+func (s *staleToken) retryInBackground() {
+	if !atomic.CompareAndSwapInt32(&s.retrying, 0, 1) {
+		return
+	}
+	go func() {
+		defer atomic.StoreInt32(&s.retrying, 0)
+		for delay := staleRetryMin; s.usable(); delay *= 2 {
+			if delay > staleRetryMax {
+				delay = staleRetryMax
+			}
+			select {
+			case <-s.stop:
+				return
+			case <-time.After(delay):
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), staleRetryMax)
+			err := s.spt.RefreshWithContext(ctx)
+			cancel()
+			if err == nil {
+				return
+			}
+		}
+	}()
+}
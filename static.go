@@ -0,0 +1,124 @@
+package azauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/adal"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// TokenRefreshFunc returns a new access token for resource along with its expiry.
+type TokenRefreshFunc func(ctx context.Context, resource string) (string, time.Time, error)
+
+// WithAccessToken selects an externally acquired access token as the credential source, such as one passed
+// to a CI job through the environment or stdin. When expiresOn is zero it is read from the token's exp claim.
+// The token is served for the resources its aud claim names, or for every resource when it has none that can
+// be compared, and refresh is called for the tokens of other resources. Tokens are kept per resource, so
+// once one is close to expiry refresh is called for its replacement alone; when refresh is nil the token is
+// served until it expires, after which authorization fails.
+func WithAccessToken(accessToken string, expiresOn time.Time, refresh TokenRefreshFunc) Option {
+	return func(c *Config) {
+		if expiresOn.IsZero() {
+			expiresOn = jwtExpiry(accessToken)
+		}
+		var mu sync.Mutex
+		tokens := map[string]staticToken{}
+
+		c.credential = func(c *Config, resource string) (*adal.ServicePrincipalToken, error) {
+			return newCustomToken(url.URL{Scheme: "static"}, resource, func(ctx context.Context, resource string) (*adal.Token, error) {
+				mu.Lock()
+				current, ok := tokens[resource]
+				mu.Unlock()
+				if !ok {
+					switch {
+					case forResource(accessToken, resource):
+						current = staticToken{value: accessToken, expiry: expiresOn}
+					case refresh == nil:
+						return nil, fmt.Errorf("the supplied access token is not for %s and no refresh function was provided", resource)
+					}
+				}
+				// the token of each resource is refreshed by its own adal token, so refreshes don't race.
+				if refresh != nil && time.Until(current.expiry) < c.dueWithin(ctx) {
+					token, expiresOn, err := refresh(ctx, resource)
+					if err != nil {
+						return nil, err
+					}
+					if expiresOn.IsZero() {
+						expiresOn = jwtExpiry(token)
+					}
+					current = staticToken{value: token, expiry: expiresOn}
+				}
+				if !time.Now().Before(current.expiry) {
+					return nil, errors.New("the supplied access token has expired and no refresh function was provided")
+				}
+				mu.Lock()
+				tokens[resource] = current
+				mu.Unlock()
+				return &adal.Token{
+					AccessToken: current.value,
+					ExpiresOn:   json.Number(strconv.FormatInt(current.expiry.Unix(), 10)),
+					Resource:    resource,
+					Type:        "Bearer",
+				}, nil
+			})
+		}
+	}
+}
+
+// staticToken is the current access token of a resource selected with WithAccessToken.
+type staticToken struct {
+	value  string
+	expiry time.Time
+}
+
+// forResource reports whether the aud claim of token, read without verifying it, names resource. Tokens
+// without a URL audience, such as opaque tokens or those issued for an application ID, can't be compared and
+// are assumed to be for every resource.
+func forResource(token, resource string) bool {
+	claims := jwt.MapClaims{}
+	if _, _, err := new(jwt.Parser).ParseUnverified(token, claims); err != nil {
+		return true
+	}
+	var audiences []string
+	switch aud := claims["aud"].(type) {
+	case string:
+		audiences = []string{aud}
+	case []interface{}:
+		for _, a := range aud {
+			if s, ok := a.(string); ok {
+				audiences = append(audiences, s)
+			}
+		}
+	}
+	compared := false
+	for _, aud := range audiences {
+		if !strings.Contains(aud, "://") {
+			continue
+		}
+		compared = true
+		if strings.TrimSuffix(aud, "/") == strings.TrimSuffix(resource, "/") {
+			return true
+		}
+	}
+	return !compared
+}
+
+// jwtExpiry returns the exp claim of token without verifying it, or the zero time when it has none.
+func jwtExpiry(token string) time.Time {
+	claims := jwt.MapClaims{}
+	if _, _, err := new(jwt.Parser).ParseUnverified(token, claims); err != nil {
+		return time.Time{}
+	}
+	if exp, ok := claims["exp"].(float64); ok {
+		return time.Unix(int64(exp), 0)
+	}
+	return time.Time{}
+}
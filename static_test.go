@@ -0,0 +1,107 @@
+package azauth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// audienceToken returns an unverified access token for aud, which may be a string or a list.
+func audienceToken(t *testing.T, aud interface{}) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"aud": aud}).SignedString([]byte("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestForResource(t *testing.T) {
+	const resource = "https://management.azure.com/"
+	for _, tc := range []struct {
+		name  string
+		token string
+		want  bool
+	}{
+		{name: "audience", token: audienceToken(t, "https://management.azure.com"), want: true},
+		{name: "one of several audiences", token: audienceToken(t, []string{"https://vault.azure.net", resource}), want: true},
+		{name: "other audience", token: audienceToken(t, "https://vault.azure.net")},
+		{name: "application ID audience", token: audienceToken(t, "00000000-0000-0000-0000-000000000000"), want: true},
+		{name: "no audience", token: redisTestToken(t, "oid"), want: true},
+		{name: "opaque", token: "opaque", want: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := forResource(tc.token, resource); got != tc.want {
+				t.Errorf("forResource() = %t, want %t", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestWithAccessTokenPerResource(t *testing.T) {
+	const arm, vault = "https://management.azure.com/", "https://vault.azure.net"
+	armToken := audienceToken(t, arm)
+	for _, tc := range []struct {
+		name string
+		// expiresIn is how long the supplied token is valid.
+		expiresIn time.Duration
+		refresh   bool
+		want      map[string]string
+		wantErr   []string
+	}{
+		{
+			name:      "other resources fail without refresh",
+			expiresIn: time.Hour,
+			want:      map[string]string{arm: armToken},
+			wantErr:   []string{vault},
+		},
+		{
+			name:      "other resources are refreshed",
+			expiresIn: time.Hour,
+			refresh:   true,
+			want:      map[string]string{arm: armToken, vault: "refreshed " + vault},
+		},
+		{
+			name:      "refreshes are per resource",
+			expiresIn: time.Minute,
+			refresh:   true,
+			want:      map[string]string{arm: "refreshed " + arm, vault: "refreshed " + vault},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var refresh TokenRefreshFunc
+			if tc.refresh {
+				refresh = func(ctx context.Context, resource string) (string, time.Time, error) {
+					return "refreshed " + resource, time.Now().Add(time.Hour), nil
+				}
+			}
+			c := newTestConfig(t, WithAccessToken(armToken, time.Now().Add(tc.expiresIn), refresh))
+			// acquire every token twice, so a refresh overwriting another resource's token would show.
+			for i := 0; i < 2; i++ {
+				for resource, want := range tc.want {
+					spt, err := c.cachedToken(resource)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if err := spt.Refresh(); err != nil {
+						t.Fatalf("%s: %v", resource, err)
+					}
+					if got := spt.OAuthToken(); got != want {
+						t.Errorf("%s: token = %q, want %q", resource, got, want)
+					}
+				}
+			}
+			for _, resource := range tc.wantErr {
+				spt, err := c.cachedToken(resource)
+				if err == nil {
+					err = spt.Refresh()
+				}
+				if err == nil {
+					t.Errorf("%s: acquired a token for a resource the supplied token isn't for", resource)
+				}
+			}
+		})
+	}
+}
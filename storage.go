@@ -0,0 +1,68 @@
+package azauth
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/azure"
+)
+
+// StorageService is a storage data plane service, named as in its endpoints.
+type StorageService string
+
+const (
+	// StorageBlob is Blob storage.
+	StorageBlob StorageService = "blob"
+	// StorageQueue is Queue storage.
+	StorageQueue StorageService = "queue"
+	// StorageTable is Table storage.
+	StorageTable StorageService = "table"
+	// StorageFile is Azure Files.
+	StorageFile StorageService = "file"
+	// StorageDFS is Data Lake Storage Gen2.
+	StorageDFS StorageService = "dfs"
+)
+
+// storageAudience returns the storage audience of env, shared by every service and account.
+func storageAudience(env azure.Environment) string {
+	return env.ResourceIdentifiers.Storage
+}
+
+// storageResource returns the storage audience of the Config's cloud, failing when the cloud has no
+// AAD authorization for storage.
+func (c *Config) storageResource() (string, error) {
+	resource := storageAudience(*c.env)
+	if resource == "" || resource == azure.NotAvailable {
+		return "", fmt.Errorf("storage AAD authorization is not available in %s", c.env.Name)
+	}
+	return resource, nil
+}
+
+// StorageEndpoint returns the endpoint of service for account in the Config's cloud, e.g.
+// https://myaccount.blob.core.windows.net/ in the public cloud.
+func (c *Config) StorageEndpoint(account string, service StorageService) string {
+	return fmt.Sprintf("https://%s.%s.%s/", account, service, strings.TrimPrefix(c.env.StorageEndpointSuffix, "."))
+}
+
+// GetStorageAuthorizer returns an authorizer for blob, queue, table, file, and Data Lake Storage Gen2
+// requests in the Config's cloud, for storage clients that accept an autorest.Authorizer. Storage data plane
+// requests also need an x-ms-version header of 2017-11-09 or later for AAD authorization, which
+// the storage SDKs set.
+func (c *Config) GetStorageAuthorizer() (autorest.Authorizer, error) {
+	resource, err := c.storageResource()
+	if err != nil {
+		return nil, err
+	}
+	return c.GetAuthorizerForResource(resource)
+}
+
+// AuthorizeStorageClient authorizes client, the autorest.Client of a Track 1 storage data plane client, with
+// GetStorageAuthorizer.
+func (c *Config) AuthorizeStorageClient(client *autorest.Client) error {
+	resource, err := c.storageResource()
+	if err != nil {
+		return err
+	}
+	return c.AuthorizeClientForResource(client, resource)
+}
@@ -0,0 +1,35 @@
+package azauth
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/azure"
+)
+
+// SynapseResource returns the audience of Synapse workspace development endpoint tokens in the Config's
+// cloud, e.g. https://dev.azuresynapse.net in the public cloud, for pipeline, Spark job, and artifact clients.
+func (c *Config) SynapseResource() (string, error) {
+	return c.serviceResource("Azure Synapse Analytics", c.env.ResourceIdentifiers.Synapse)
+}
+
+// SynapseEndpoint returns the development endpoint of workspace in the Config's cloud, e.g.
+// https://myworkspace.dev.azuresynapse.net in the public cloud.
+func (c *Config) SynapseEndpoint(workspace string) (string, error) {
+	suffix := c.env.SynapseEndpointSuffix
+	if suffix == "" || suffix == azure.NotAvailable {
+		return "", fmt.Errorf("Azure Synapse Analytics is not available in %s", c.env.Name)
+	}
+	return "https://" + workspace + "." + strings.TrimPrefix(suffix, "."), nil
+}
+
+// AuthorizeSynapseClient authorizes client, the autorest.Client of a Synapse development endpoint client,
+// for SynapseResource.
+func (c *Config) AuthorizeSynapseClient(client *autorest.Client) error {
+	resource, err := c.SynapseResource()
+	if err != nil {
+		return err
+	}
+	return c.AuthorizeClientForResource(client, resource)
+}
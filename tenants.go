@@ -0,0 +1,119 @@
+package azauth
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Azure/go-autorest/autorest/azure/auth"
+)
+
+const (
+	// additionallyAllowedTenantsEnv lists the tenants beyond the configured one tokens may be acquired for,
+	// separated by semicolons, as in azidentity.
+	additionallyAllowedTenantsEnv = "AZURE_ADDITIONALLY_ALLOWED_TENANTS"
+	// clientCertificatePathEnv and clientCertificatePasswordEnv are azidentity's names for
+	// AZURE_CERTIFICATE_PATH and AZURE_CERTIFICATE_PASSWORD.
+	clientCertificatePathEnv     = "AZURE_CLIENT_CERTIFICATE_PATH"
+	clientCertificatePasswordEnv = "AZURE_CLIENT_CERTIFICATE_PASSWORD"
+	// sendCertificateChainEnv enables x5c like WithX5C when true.
+	sendCertificateChainEnv = "AZURE_CLIENT_SEND_CERTIFICATE_CHAIN"
+)
+
+// azidentitySettings fills the certificate settings of settings from the variables azidentity reads,
+// so services migrating between the libraries can keep one set of configuration. The names read by
+// auth.GetSettingsFromEnvironment take precedence.
+func azidentitySettings(settings *auth.EnvironmentSettings) {
+	for name, alias := range map[string]string{
+		auth.CertificatePath:     clientCertificatePathEnv,
+		auth.CertificatePassword: clientCertificatePasswordEnv,
+	} {
+		if settings.Values[name] != "" {
+			continue
+		}
+		if v := os.Getenv(alias); v != "" {
+			settings.Values[name] = v
+		}
+	}
+}
+
+// readAzidentityEnvironment applies the azidentity variables that configure the Config rather than credentials.
+func (c *Config) readAzidentityEnvironment() {
+	switch strings.ToLower(os.Getenv(sendCertificateChainEnv)) {
+	case "1", "true":
+		c.sendX5C = true
+	}
+	for _, tenant := range strings.Split(os.Getenv(additionallyAllowedTenantsEnv), ";") {
+		if tenant = strings.TrimSpace(tenant); tenant != "" {
+			c.allowedTenants = append(c.allowedTenants, tenant)
+		}
+	}
+}
+
+// WithAdditionallyAllowedTenants allows TokenCredential to acquire tokens for tenants other than the configured
+// one when an SDK client requests them, e.g. after a cross-tenant challenge. "*" allows any tenant.
+// AZURE_ADDITIONALLY_ALLOWED_TENANTS is read the same way, with tenants separated by semicolons.
+func WithAdditionallyAllowedTenants(tenants ...string) Option {
+	return func(c *Config) {
+		c.allowedTenants = append(c.allowedTenants, tenants...)
+	}
+}
+
+// tenantAllowed reports whether tokens may be acquired for tenant.
+func (c *Config) tenantAllowed(tenant string) bool {
+	if tenant == "" || strings.EqualFold(tenant, c.tenantID()) {
+		return true
+	}
+	for _, allowed := range c.allowedTenants {
+		if allowed == "*" || strings.EqualFold(allowed, tenant) {
+			return true
+		}
+	}
+	return false
+}
+
+// forTenant returns the Config acquiring tokens in tenant: c itself for the configured tenant, and otherwise
+// a Config constructed with c's options for tenant, created once and closed with c.
+func (c *Config) forTenant(tenant string) (*Config, error) {
+	if tenant == "" || strings.EqualFold(tenant, c.tenantID()) {
+		return c, nil
+	}
+	if !c.tenantAllowed(tenant) {
+		return nil, fmt.Errorf("tokens for tenant %q were requested, but only tenant %q is configured; "+
+			"allow it with WithAdditionallyAllowedTenants or %s", tenant, c.tenantID(), additionallyAllowedTenantsEnv)
+	}
+	if c.closed() {
+		return nil, errClosed
+	}
+
+	c.tenantsMu.Lock()
+	defer c.tenantsMu.Unlock()
+	key := strings.ToLower(tenant)
+	if t, ok := c.tenantConfigs[key]; ok {
+		return t, nil
+	}
+	opts := append(c.opts[:len(c.opts):len(c.opts)], Tenant(tenant))
+	t, err := New(opts...)
+	if err != nil {
+		return nil, err
+	}
+	if c.tenantConfigs == nil {
+		c.tenantConfigs = map[string]*Config{}
+	}
+	c.tenantConfigs[key] = t
+	return t, nil
+}
+
+// closeTenants closes and forgets the Configs of other tenants.
+func (c *Config) closeTenants() error {
+	c.tenantsMu.Lock()
+	tenants := c.tenantConfigs
+	c.tenantConfigs = nil
+	c.tenantsMu.Unlock()
+	var errs []error
+	for _, t := range tenants {
+		errs = append(errs, t.Close())
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,69 @@
+package azauth
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Azure/go-autorest/autorest/azure"
+	"github.com/Azure/go-autorest/autorest/azure/auth"
+)
+
+// armEnvironmentName is the variable the Terraform AzureRM provider selects the cloud with.
+const armEnvironmentName = "ARM_ENVIRONMENT"
+
+// armSettings maps the Terraform AzureRM provider's variables to the settings they stand in for.
+var armSettings = map[string]string{
+	"ARM_CLIENT_ID":       auth.ClientID,
+	"ARM_CLIENT_SECRET":   auth.ClientSecret,
+	"ARM_TENANT_ID":       auth.TenantID,
+	"ARM_SUBSCRIPTION_ID": auth.SubscriptionID,
+}
+
+// armEnvironments maps the cloud names of the Terraform AzureRM provider to azure's.
+var armEnvironments = map[string]string{
+	"public":       azure.PublicCloud.Name,
+	"usgovernment": azure.USGovernmentCloud.Name,
+	"china":        azure.ChinaCloud.Name,
+	"german":       azure.GermanCloud.Name,
+}
+
+// WithARMEnvironment reads the credentials and cloud from the ARM_CLIENT_ID, ARM_CLIENT_SECRET, ARM_TENANT_ID,
+// ARM_SUBSCRIPTION_ID, and ARM_ENVIRONMENT variables of the Terraform AzureRM provider, so tooling running
+// alongside Terraform can share one set of variables. Set ARM_* variables take precedence over their AZURE_*
+// counterparts, and Reload reads them again. ARM_ENVIRONMENT accepts Terraform's names, e.g. public or
+// usgovernment, as well as azure's; an unknown name fails New.
+func WithARMEnvironment() Option {
+	return func(c *Config) {
+		c.armEnvironment = true
+		armEnvironmentSettings(&c.settings)
+		name := os.Getenv(armEnvironmentName)
+		if name == "" {
+			return
+		}
+		if azureName, ok := armEnvironments[strings.ToLower(name)]; ok {
+			name = azureName
+		}
+		env, err := azure.EnvironmentFromName(name)
+		if err != nil {
+			c.optionErrs = append(c.optionErrs, fmt.Errorf("%s: %w", armEnvironmentName, err))
+			return
+		}
+		c.env = &env
+	}
+}
+
+// armEnvironmentSettings overrides the settings of settings with the ARM_* variables that are set.
+func armEnvironmentSettings(settings *auth.EnvironmentSettings) {
+	for arm, name := range armSettings {
+		if v := os.Getenv(arm); v != "" {
+			settings.Values[name] = v
+		}
+	}
+}
+
+// SubscriptionID returns the subscription ID found in the environment, AZURE_SUBSCRIPTION_ID or, with
+// WithARMEnvironment, ARM_SUBSCRIPTION_ID.
+func (c *Config) SubscriptionID() string {
+	return c.setting(auth.SubscriptionID)
+}
@@ -0,0 +1,51 @@
+package azauth
+
+import (
+	"context"
+	"net/url"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/adal"
+)
+
+// WithAcquireTimeout bounds each token request to AAD and other token services to timeout, so a hung
+// endpoint fails the request instead of stalling every caller waiting on the token. Managed identity
+// requests are bounded separately with WithIMDSAcquireTimeout. Requests are still bounded by the caller's
+// context, and each retry of a retry policy gets the full timeout.
+func WithAcquireTimeout(timeout time.Duration) Option {
+	return func(c *Config) {
+		c.acquireTimeout = timeout
+	}
+}
+
+// WithIMDSAcquireTimeout bounds each token request to IMDS or another managed identity endpoint to timeout.
+func WithIMDSAcquireTimeout(timeout time.Duration) Option {
+	return func(c *Config) {
+		c.imdsAcquireTimeout = timeout
+	}
+}
+
+// bounded wraps cred so each of its refreshes is bounded by timeout, when positive. Every credential a Config
+// acquires tokens with is bounded, so the tokens cred creates are also given the configured client here,
+// before the layers wrapping them hide them.
+func (c *Config) bounded(cred credential, timeout time.Duration) credential {
+	return func(c *Config, resource string) (*adal.ServicePrincipalToken, error) {
+		inner, err := cred(c, resource)
+		if err != nil {
+			return nil, err
+		}
+		c.useClient(inner)
+		if timeout <= 0 {
+			return inner, nil
+		}
+		return newCustomToken(url.URL{Scheme: "timeout"}, resource, func(ctx context.Context, resource string) (*adal.Token, error) {
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			if err := inner.RefreshWithContext(ctx); err != nil {
+				return nil, err
+			}
+			token := inner.Token()
+			return &token, nil
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package azauth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+)
+
+func TestAcquireTimeout(t *testing.T) {
+	const timeout = 100 * time.Millisecond
+	for _, tc := range []struct {
+		name      string
+		opts      []Option
+		authorize func(c *Config) (autorest.Authorizer, error)
+	}{
+		{
+			name: "configured source",
+			opts: []Option{WithClientSecret("tenant", "client", "secret")},
+			authorize: func(c *Config) (autorest.Authorizer, error) {
+				return c.GetAuthorizerForResource("https://resource.example.com")
+			},
+		},
+		{
+			name: "token sent by adal",
+			opts: []Option{WithClientSecret("adfs", "client", "secret"), WithADFS()},
+			authorize: func(c *Config) (autorest.Authorizer, error) {
+				return c.GetAuthorizerForResource("https://resource.example.com")
+			},
+		},
+		{
+			name: "args",
+			opts: []Option{App("client"), Key("secret"), Tenant("tenant")},
+			authorize: func(c *Config) (autorest.Authorizer, error) {
+				return c.GetAuthorizerFromArgs()
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			aad := newAADServer(t)
+			aad.hang()
+			c := newTestConfig(t, append(append(aad.options(), WithAcquireTimeout(timeout)), tc.opts...)...)
+			authorizer, err := tc.authorize(c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			spt, _ := ServicePrincipalToken(authorizer)
+
+			done := make(chan error, 1)
+			go func() { done <- spt.Refresh() }()
+			select {
+			case err := <-done:
+				// adal doesn't wrap the errors of its own requests, so only a failure is required of it.
+				if err == nil {
+					t.Error("Refresh() succeeded against a hung token service")
+				}
+				// reaching the server also shows the token was sent with the configured client.
+				if aad.tokenRequests() != 1 {
+					t.Errorf("token requests = %d, want 1", aad.tokenRequests())
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("the refresh outlived the acquire timeout")
+			}
+		})
+	}
+}
@@ -0,0 +1,208 @@
+package azauth
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/adal"
+)
+
+const (
+	// customClientID identifies tokens whose refreshes are handled by azauth rather than adal.
+	customClientID = "azauth"
+
+	// defaultRefreshWithin matches the window before expiry in which adal refreshes tokens by default.
+	defaultRefreshWithin = 5 * time.Minute
+)
+
+// newCustomToken returns a token for resource whose refreshes are delegated to refresh,
+// for credential sources adal has no native support for. endpoint is informational only.
+func newCustomToken(endpoint url.URL, resource string, refresh adal.TokenRefresh) (*adal.ServicePrincipalToken, error) {
+	spt, err := adal.NewServicePrincipalTokenWithSecret(adal.OAuthConfig{TokenEndpoint: endpoint}, customClientID, resource, &adal.ServicePrincipalNoSecret{})
+	if err != nil {
+		return nil, err
+	}
+	spt.SetCustomRefreshFunc(refresh)
+	return spt, nil
+}
+
+// requestToken posts v to the token endpoint of oauthConfig and decodes the issued token.
+// The v1 resource parameter is translated to a scope for v2 endpoints, and claims are requested
+// for CAE and claims challenges.
+func (c *Config) requestToken(ctx context.Context, oauthConfig adal.OAuthConfig, v url.Values) (*adal.Token, error) {
+	toV2(oauthConfig.TokenEndpoint, v)
+	claims, err := c.tokenClaims(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if claims != "" {
+		v.Set("claims", claims)
+	}
+	req, err := http.NewRequest(http.MethodPost, oauthConfig.TokenEndpoint.String(), strings.NewReader(v.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return doTokenRequest(c.httpClient(), req.WithContext(ctx))
+}
+
+// doTokenRequest sends req with client and decodes the token in the response body.
+// Token endpoints disagree on expiry fields, so expires_on is derived from expires_in when missing.
+func doTokenRequest(client *http.Client, req *http.Request) (*adal.Token, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	buf := getBuffer()
+	defer putBuffer(buf)
+	if _, err := buf.ReadFrom(resp.Body); err != nil {
+		return nil, err
+	}
+	body := buf.Bytes()
+	if resp.StatusCode != http.StatusOK {
+		return nil, tokenRequestError{
+			message: fmt.Sprintf("token request to %s failed with status %d: %s", req.URL.Host, resp.StatusCode, body),
+			resp:    resp,
+		}
+	}
+	var token adal.Token
+	if err := json.Unmarshal(body, &token); err != nil {
+		return nil, err
+	}
+	if token.ExpiresOn == "" {
+		expiresIn, err := token.ExpiresIn.Int64()
+		if err != nil {
+			return nil, fmt.Errorf("token response has no usable expiry: %v", err)
+		}
+		token.ExpiresOn = json.Number(strconv.FormatInt(time.Now().Add(time.Duration(expiresIn)*time.Second).Unix(), 10))
+	}
+	return &token, nil
+}
+
+// tokenRequestError is a failed token request. It implements adal.TokenRefreshError, so callers can inspect
+// the response as they would for adal's own requests.
+type tokenRequestError struct {
+	message string
+	resp    *http.Response
+}
+
+// Error implements error.
+func (e tokenRequestError) Error() string {
+	return e.message
+}
+
+// Response implements adal.TokenRefreshError.
+func (e tokenRequestError) Response() *http.Response {
+	return e.resp
+}
+
+// setClientCredentials authenticates the application in token request v, with a client certificate
+// assertion for tokenEndpoint when a certificate is configured and with its client secret otherwise.
+func (c *Config) setClientCredentials(v url.Values, tokenEndpoint url.URL) error {
+	if chain, key := c.clientCertificate(); key != nil {
+		return setClientAssertion(v, chain, key, tokenEndpoint, c.clientID())
+	}
+	secret := c.clientSecret()
+	if secret == "" {
+		return errors.New("a client secret or certificate must be provided")
+	}
+	v.Set("client_secret", secret)
+	return nil
+}
+
+// setClientAssertion sets a client assertion for clientID signed by key on the token request v.
+func setClientAssertion(v url.Values, chain []*x509.Certificate, key *rsa.PrivateKey, tokenEndpoint url.URL, clientID string) error {
+	assertion, err := signAssertion(chain, key, tokenEndpoint.String(), clientID)
+	if err != nil {
+		return err
+	}
+	v.Set("client_assertion_type", "urn:ietf:params:oauth:client-assertion-type:jwt-bearer")
+	v.Set("client_assertion", assertion)
+	return nil
+}
+
+// scopeForResource converts an AAD v1 resource into the equivalent v2 default scope.
+func scopeForResource(resource string) string {
+	return strings.TrimSuffix(resource, "/") + "/.default"
+}
+
+// GetServicePrincipalTokenForResource fetches the token for resource from the configured credential source,
+// for callers that need the token itself rather than an authorizer, e.g. to register refresh callbacks.
+func (c *Config) GetServicePrincipalTokenForResource(resource string) (*adal.ServicePrincipalToken, error) {
+	return c.cachedToken(resource)
+}
+
+// WithRefreshWindow sets how long before expiry tokens are considered expired and refreshed, 5 minutes by
+// default. Larger windows tolerate clock skew and keep tokens valid through long running operations, such
+// as uploads, that start shortly before a refresh would otherwise be due. The window must stay shorter than
+// the lifetime of issued tokens, or every request acquires a new token.
+func WithRefreshWindow(window time.Duration) Option {
+	return func(c *Config) {
+		c.refreshWithin = window
+	}
+}
+
+// refreshWindow returns the window before expiry in which tokens are refreshed.
+func (c *Config) refreshWindow() time.Duration {
+	if c.refreshWithin > 0 {
+		return c.refreshWithin
+	}
+	return defaultRefreshWithin
+}
+
+// WithTokenRefreshCallbacks registers callbacks on every token the Config issues. They run after each
+// refresh with the new token, e.g. to re-sign long lived websocket connections.
+func WithTokenRefreshCallbacks(callbacks ...adal.TokenRefreshCallback) Option {
+	return func(c *Config) {
+		c.refreshCallbacks = append(c.refreshCallbacks, callbacks...)
+	}
+}
+
+// OnTokenRefresh subscribes callback to the refreshes of every token the Config issues, including tokens
+// issued before it subscribed. It runs after each refresh with the token's resource and new expiry, e.g. to
+// log rotations, re-sign downstream URLs, or emit metrics, and must not block.
+func (c *Config) OnTokenRefresh(callback func(resource string, expiresOn time.Time)) {
+	c.subscribersMu.Lock()
+	defer c.subscribersMu.Unlock()
+	c.subscribers = append(c.subscribers, callback)
+}
+
+// notifyRefresh returns a refresh callback for tokens of resource that notifies the OnTokenRefresh subscribers.
+func (c *Config) notifyRefresh(resource string) adal.TokenRefreshCallback {
+	return func(token adal.Token) error {
+		c.subscribersMu.RLock()
+		subscribers := c.subscribers
+		c.subscribersMu.RUnlock()
+		for _, subscriber := range subscribers {
+			subscriber(resource, token.Expires())
+		}
+		return nil
+	}
+}
+
+// ServicePrincipalToken returns the token behind an authorizer issued by a Config.
+func ServicePrincipalToken(authorizer autorest.Authorizer) (*adal.ServicePrincipalToken, bool) {
+	switch a := authorizer.(type) {
+	case *autorest.BearerAuthorizer:
+		switch provider := a.TokenProvider().(type) {
+		case *adal.ServicePrincipalToken:
+			return provider, true
+		case *staleToken:
+			return provider.spt, true
+		}
+	case *popAuthorizer:
+		return a.spt, true
+	}
+	return nil, false
+}
@@ -0,0 +1,88 @@
+package azauth
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Azure/go-autorest/logger"
+)
+
+const (
+	// keychainService names the keychain entries of KeychainTokenStore when it has no Service.
+	keychainService = "azauth"
+	// keychainTimeout bounds keychain access, which may hang when no keyring daemon is running.
+	keychainTimeout = 5 * time.Second
+)
+
+// errNotInKeychain is returned by readKeychain when the keychain has no entry for the service and account.
+var errNotInKeychain = errors.New("the secret is not in the keychain")
+
+// TokenStore persists the refresh tokens of signed in users across processes, keyed by tenant and client ID,
+// so developer tools using interactive or device code sign in only prompt when no usable token is stored.
+type TokenStore interface {
+	// Load returns the refresh token saved under key, or an empty string if there is none.
+	Load(key string) (string, error)
+	// Save replaces the refresh token saved under key.
+	Save(key, refreshToken string) error
+}
+
+// WithTokenStore persists the refresh tokens of interactive and device code sign ins to store.
+func WithTokenStore(store TokenStore) Option {
+	return func(c *Config) {
+		c.tokenStore = store
+	}
+}
+
+// KeychainTokenStore keeps refresh tokens in the platform keychain: the macOS Keychain, the Windows
+// Credential Manager, or the freedesktop Secret Service on Linux.
+type KeychainTokenStore struct {
+	// Service names the keychain entries, azauth when empty.
+	Service string
+}
+
+// Load implements TokenStore. Missing entries are reported as errors, as are a locked or unavailable keychain.
+func (s KeychainTokenStore) Load(key string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), keychainTimeout)
+	defer cancel()
+	return readKeychain(ctx, s.service(), key)
+}
+
+// Save implements TokenStore.
+func (s KeychainTokenStore) Save(key, refreshToken string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), keychainTimeout)
+	defer cancel()
+	return writeKeychain(ctx, s.service(), key, refreshToken)
+}
+
+func (s KeychainTokenStore) service() string {
+	if s.Service == "" {
+		return keychainService
+	}
+	return s.Service
+}
+
+// loadRefreshToken returns the refresh token stored under key, if a store is configured.
+// Failures are logged and treated as a missing token, so the user is prompted instead.
+func (c *Config) loadRefreshToken(key string) string {
+	if c.tokenStore == nil {
+		return ""
+	}
+	refreshToken, err := c.tokenStore.Load(key)
+	if err != nil {
+		logger.Instance.Writef(logger.LogInfo, "azauth: no refresh token loaded from the token store: %v\n", err)
+		return ""
+	}
+	return refreshToken
+}
+
+// saveRefreshToken saves refreshToken under key, if a store is configured. Failures are logged, since the
+// token remains usable for the lifetime of the process.
+func (c *Config) saveRefreshToken(key, refreshToken string) {
+	if c.tokenStore == nil || refreshToken == "" {
+		return
+	}
+	if err := c.tokenStore.Save(key, refreshToken); err != nil {
+		logger.Instance.Writef(logger.LogWarning, "azauth: failed to save the refresh token to the token store: %v\n", err)
+	}
+}
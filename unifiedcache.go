@@ -0,0 +1,181 @@
+package azauth
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/adal"
+	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/cache"
+	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/public"
+)
+
+const (
+	// azureCLIClientID is the public client the Azure CLI signs users in with.
+	azureCLIClientID = "04b07795-8ddb-461a-bbee-02f9e1bf7b46"
+	// azureConfigDirEnv overrides the Azure CLI configuration directory, ~/.azure by default.
+	azureConfigDirEnv = "AZURE_CONFIG_DIR"
+	// azureCLICacheFile is the unencrypted MSAL cache the Azure CLI keeps on Linux and macOS.
+	azureCLICacheFile = "msal_token_cache.json"
+)
+
+// UnifiedTokenCache persists MSAL token caches to a file in the MSAL unified cache schema, the format the
+// Azure CLI and other MSAL applications share, so tokens acquired by one can be silently reused by the others.
+// Reads and writes hold an advisory lock on <path>.lockfile, as MSAL extensions and the Azure CLI do, so
+// processes sharing the file don't overwrite each other's tokens.
+type UnifiedTokenCache struct {
+	Path string
+}
+
+// Replace implements cache.ExportReplace. A missing file is an empty cache.
+func (u UnifiedTokenCache) Replace(ctx context.Context, c cache.Unmarshaler, _ cache.ReplaceHints) error {
+	unlock, err := u.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	b, err := ioutil.ReadFile(u.Path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return c.Unmarshal(b)
+}
+
+// Export implements cache.ExportReplace, replacing the file atomically.
+func (u UnifiedTokenCache) Export(ctx context.Context, c cache.Marshaler, _ cache.ExportHints) error {
+	b, err := c.Marshal()
+	if err != nil {
+		return err
+	}
+	unlock, err := u.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	f, err := ioutil.TempFile(filepath.Dir(u.Path), filepath.Base(u.Path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), u.Path); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
+
+// lock takes the cache's lock file, waiting at most persistTimeout for other processes to release it.
+func (u UnifiedTokenCache) lock(ctx context.Context) (func(), error) {
+	ctx, cancel := context.WithTimeout(ctx, persistTimeout)
+	defer cancel()
+	return lockFile(ctx, u.Path+".lockfile")
+}
+
+// AzureCLITokenCachePath returns the path of the Azure CLI's MSAL token cache. The Azure CLI encrypts
+// its cache on Windows, so it can only be shared on Linux and macOS.
+func AzureCLITokenCachePath() (string, error) {
+	dir := os.Getenv(azureConfigDirEnv)
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(home, ".azure")
+	}
+	return filepath.Join(dir, azureCLICacheFile), nil
+}
+
+// WithUnifiedTokenCache persists the tokens of MSAL confidential clients to the unified cache at path.
+func WithUnifiedTokenCache(path string) Option {
+	return func(c *Config) {
+		c.msalCache = UnifiedTokenCache{Path: path}
+	}
+}
+
+// WithSharedTokenCache selects the accounts in an MSAL unified cache as the credential source, so a developer
+// signed in to the Azure CLI is reused without prompting. path is the cache, the Azure CLI's when empty, and
+// username selects the account when the cache holds several. Tokens are redeemed for the Azure CLI's client
+// ID unless one is set with App, and refreshed tokens are written back to the cache.
+func WithSharedTokenCache(path, username string) Option {
+	return func(c *Config) {
+		c.credential = func(c *Config, resource string) (*adal.ServicePrincipalToken, error) {
+			cachePath := path
+			if cachePath == "" {
+				var err error
+				if cachePath, err = AzureCLITokenCachePath(); err != nil {
+					return nil, err
+				}
+			}
+			clientID := c.app
+			if clientID == "" {
+				clientID = azureCLIClientID
+			}
+			tenant := c.tenantID()
+			if tenant == "" {
+				tenant = organizationsTenant
+			}
+			authority := strings.TrimSuffix(c.env.ActiveDirectoryEndpoint, "/") + "/" + tenant
+			clientOpts := []public.Option{public.WithAuthority(authority), public.WithCache(UnifiedTokenCache{Path: cachePath})}
+			if c.noInstanceDiscovery {
+				clientOpts = append(clientOpts, public.WithInstanceDiscovery(false))
+			}
+			if c.client != nil {
+				clientOpts = append(clientOpts, public.WithHTTPClient(c.client))
+			}
+			client, err := public.New(clientID, clientOpts...)
+			if err != nil {
+				return nil, err
+			}
+			endpoint, err := url.Parse(authority)
+			if err != nil {
+				return nil, err
+			}
+
+			return newCustomToken(*endpoint, resource, func(ctx context.Context, resource string) (*adal.Token, error) {
+				accounts, err := client.Accounts(ctx)
+				if err != nil {
+					return nil, err
+				}
+				var selected []public.Account
+				for _, account := range accounts {
+					if username == "" || strings.EqualFold(account.PreferredUsername, username) {
+						selected = append(selected, account)
+					}
+				}
+				switch {
+				case len(selected) == 0:
+					return nil, errors.New("no matching account was found in the shared token cache " + cachePath)
+				case len(selected) > 1:
+					return nil, errors.New("the shared token cache holds several accounts, select one by username")
+				}
+				opts := []public.AcquireSilentOption{public.WithSilentAccount(selected[0])}
+				if claims, _ := ctx.Value(claimsKey{}).(string); claims != "" {
+					opts = append(opts, public.WithClaims(claims))
+				}
+				start := time.Now()
+				result, err := client.AcquireTokenSilent(ctx, []string{scopeForResource(resource)}, opts...)
+				c.reportMSAL(resource, result, start, err)
+				if err != nil {
+					return nil, err
+				}
+				return fromAuthResult(result, resource), nil
+			})
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package azauth
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/cache"
+)
+
+// rawCache is an MSAL cache holding its serialized form.
+type rawCache struct {
+	data []byte
+}
+
+func (r *rawCache) Marshal() ([]byte, error) {
+	return r.data, nil
+}
+
+func (r *rawCache) Unmarshal(b []byte) error {
+	r.data = b
+	return nil
+}
+
+func TestUnifiedTokenCache(t *testing.T) {
+	u := UnifiedTokenCache{Path: filepath.Join(t.TempDir(), "msal", "cache.json")}
+	ctx := context.Background()
+
+	var empty rawCache
+	if err := u.Replace(ctx, &empty, cache.ReplaceHints{}); err != nil {
+		t.Fatalf("Replace of a missing cache: %v", err)
+	}
+	if empty.data != nil {
+		t.Fatalf("missing cache read as %q", empty.data)
+	}
+
+	const writers = 16
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			payload := &rawCache{data: []byte(fmt.Sprintf(`{"writer":%d,"padding":%q}`, i, strings.Repeat("x", 4096)))}
+			if err := u.Export(ctx, payload, cache.ExportHints{}); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	var got rawCache
+	if err := u.Replace(ctx, &got, cache.ReplaceHints{}); err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for i := 0; i < writers; i++ {
+		if string(got.data) == fmt.Sprintf(`{"writer":%d,"padding":%q}`, i, strings.Repeat("x", 4096)) {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("cache holds a torn write: %.60q", got.data)
+	}
+	if matches, _ := filepath.Glob(u.Path + ".*.tmp"); len(matches) != 0 {
+		t.Errorf("temporary files were left behind: %v", matches)
+	}
+}
@@ -0,0 +1,74 @@
+package azauth
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/Azure/go-autorest/autorest/adal"
+	"github.com/Azure/go-autorest/autorest/azure"
+)
+
+const (
+	// vscodeClientID is the application the VS Code Azure Account extension signs in with.
+	vscodeClientID = "aebc6443-996d-45c2-90f0-388ff96faa56"
+	// vscodeService is the keychain service the extension stores refresh tokens under, one account per cloud.
+	vscodeService = "VS Code Azure"
+	// organizationsTenant addresses any work or school account's tenant, for user credentials without a tenant.
+	organizationsTenant = "organizations"
+)
+
+// vscodeClouds maps environments to the cloud names the extension stores refresh tokens under.
+var vscodeClouds = map[string]string{
+	azure.PublicCloud.Name:       "AzureCloud",
+	azure.ChinaCloud.Name:        "AzureChinaCloud",
+	azure.USGovernmentCloud.Name: "AzureUSGovernment",
+	azure.GermanCloud.Name:       "AzureGermanCloud",
+}
+
+// WithVisualStudioCode selects the refresh token stored by the VS Code Azure Account extension as the
+// credential source, for developer machines. The token is read from the OS keychain once; rotated tokens
+// are kept in memory and never written back to the extension's store. The tenant set with Tenant or
+// AZURE_TENANT_ID is used when present.
+func WithVisualStudioCode() Option {
+	return func(c *Config) {
+		var mu sync.Mutex
+		var store RefreshTokenStore
+		c.credential = func(c *Config, resource string) (*adal.ServicePrincipalToken, error) {
+			if err := func() error {
+				mu.Lock()
+				defer mu.Unlock()
+				if store != nil {
+					return nil
+				}
+				cloud, ok := vscodeClouds[c.env.Name]
+				if !ok {
+					return errors.New("visual studio code does not support the " + c.env.Name + " environment")
+				}
+				ctx, cancel := context.WithTimeout(context.Background(), keychainTimeout)
+				defer cancel()
+				refreshToken, err := readKeychain(ctx, vscodeService, cloud)
+				if err != nil {
+					return err
+				}
+				if refreshToken == "" {
+					return errors.New("no visual studio code azure account session was found, sign in with the Azure Account extension")
+				}
+				store = NewMemoryRefreshTokenStore(refreshToken)
+				return nil
+			}(); err != nil {
+				return nil, err
+			}
+
+			tenant := c.tenantID()
+			if tenant == "" {
+				tenant = organizationsTenant
+			}
+			oauthConfig, err := adal.NewOAuthConfig(c.env.ActiveDirectoryEndpoint, tenant)
+			if err != nil {
+				return nil, err
+			}
+			return c.refreshTokenCredential(*oauthConfig, vscodeClientID, resource, &mu, store)
+		}
+	}
+}